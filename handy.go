@@ -128,3 +128,83 @@ func Exists(data []byte, keys ...string) bool {
 	handyPool.Put(p)
 	return ok
 }
+
+// getByPointer parses data and calls f with the value the JSON Pointer
+// pointer refers to.
+func getByPointer(data []byte, pointer string, f func(v *Value) error) error {
+	ptr, err := ParsePointer(pointer)
+	if err != nil {
+		return err
+	}
+	p := handyPool.Get()
+	defer handyPool.Put(p)
+	v, err := p.ParseBytes(data)
+	if err != nil {
+		return err
+	}
+	if v, err = ptr.Get(v); err != nil {
+		return err
+	}
+	return f(v)
+}
+
+// GetStringByPointer returns string value for the field identified by the
+// JSON Pointer pointer in JSON data.
+//
+// A *PointerError is returned if pointer can't be resolved in data.
+func GetStringByPointer(data []byte, pointer string) (string, error) {
+	var s string
+	err := getByPointer(data, pointer, func(v *Value) error {
+		sb, err := v.StringBytes()
+		s = string(sb)
+		return err
+	})
+	return s, err
+}
+
+// GetIntByPointer returns int value for the field identified by the
+// JSON Pointer pointer in JSON data.
+//
+// A *PointerError is returned if pointer can't be resolved in data.
+func GetIntByPointer(data []byte, pointer string) (int, error) {
+	var n int
+	err := getByPointer(data, pointer, func(v *Value) (err error) {
+		n, err = v.Int()
+		return err
+	})
+	return n, err
+}
+
+// GetFloat64ByPointer returns float64 value for the field identified by the
+// JSON Pointer pointer in JSON data.
+//
+// A *PointerError is returned if pointer can't be resolved in data.
+func GetFloat64ByPointer(data []byte, pointer string) (float64, error) {
+	var f float64
+	err := getByPointer(data, pointer, func(v *Value) (err error) {
+		f, err = v.Float64()
+		return err
+	})
+	return f, err
+}
+
+// GetBoolByPointer returns bool value for the field identified by the
+// JSON Pointer pointer in JSON data.
+//
+// A *PointerError is returned if pointer can't be resolved in data.
+func GetBoolByPointer(data []byte, pointer string) (bool, error) {
+	var b bool
+	err := getByPointer(data, pointer, func(v *Value) (err error) {
+		b, err = v.Bool()
+		return err
+	})
+	return b, err
+}
+
+// ExistsByPointer returns true if the field identified by the JSON Pointer
+// pointer exists in JSON data.
+//
+// False is returned on error.
+func ExistsByPointer(data []byte, pointer string) bool {
+	return getByPointer(data, pointer, func(v *Value) error { return nil }) == nil
+}
@@ -232,3 +232,57 @@ func TestExists(t *testing.T) {
 		t.Fatalf("Exists returned true on invalid json")
 	}
 }
+
+func TestGetByPointer(t *testing.T) {
+	data := []byte(`{"foo":{"bar":["baz", 1234, 12.5, true]}, "a/b": "slash"}`)
+
+	s, err := GetStringByPointer(data, "/foo/bar/0")
+	if err != nil || s != "baz" {
+		t.Fatalf("unexpected value obtained; got %q, %v; want %q", s, err, "baz")
+	}
+	s, err = GetStringByPointer(data, "/a~1b")
+	if err != nil || s != "slash" {
+		t.Fatalf("unexpected value obtained; got %q, %v; want %q", s, err, "slash")
+	}
+	n, err := GetIntByPointer(data, "/foo/bar/1")
+	if err != nil || n != 1234 {
+		t.Fatalf("unexpected value obtained; got %d, %v; want %d", n, err, 1234)
+	}
+	f, err := GetFloat64ByPointer(data, "/foo/bar/2")
+	if err != nil || f != 12.5 {
+		t.Fatalf("unexpected value obtained; got %f, %v; want %f", f, err, 12.5)
+	}
+	b, err := GetBoolByPointer(data, "/foo/bar/3")
+	if err != nil || !b {
+		t.Fatalf("unexpected value obtained; got %v, %v; want true", b, err)
+	}
+	if !ExistsByPointer(data, "/foo/bar") {
+		t.Fatalf("expecting existing value at /foo/bar")
+	}
+
+	// non-existing path
+	if _, err = GetStringByPointer(data, "/foo/zzz"); err == nil || err.(*PointerError).Err != ErrKeyNotFound {
+		t.Fatalf("unexpected error for missing key: %v", err)
+	}
+	if _, err = GetIntByPointer(data, "/foo/bar/4"); err == nil || err.(*PointerError).Err != ErrIndexOutOfRange {
+		t.Fatalf("unexpected error for index out of range: %v", err)
+	}
+	if ExistsByPointer(data, "/foo/bar/0/x") {
+		t.Fatalf("unexpected existing value at /foo/bar/0/x")
+	}
+
+	// invalid type
+	if _, err = GetStringByPointer(data, "/foo/bar/1"); err == nil {
+		t.Fatalf("expecting non-nil error for invalid type")
+	}
+
+	// invalid pointer
+	if _, err = GetStringByPointer(data, "foo"); err == nil {
+		t.Fatalf("expecting non-nil error for invalid pointer")
+	}
+
+	// invalid json
+	if _, err = GetStringByPointer([]byte("invalid json"), "/foo"); err == nil {
+		t.Fatalf("expecting non-nil error for invalid json")
+	}
+}
@@ -1,8 +1,13 @@
 package jsonq
 
 import (
+	"bufio"
 	"bytes"
 	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
 )
 
 func (v Value) check(filter Filter) bool {
@@ -22,8 +27,119 @@ func (v Value) check(filter Filter) bool {
 	}
 }
 
-// Search return an Array of interface values by the given keys path
+// Match is a value found by SearchDeep.
+type Match struct {
+	// Path is the keys path of Value from the searched value.
+	//
+	// Array indexes are represented as decimal numbers.
+	Path []string
+
+	// Value is the value found.
+	Value *Value
+}
+
+// SearchDeep returns the values stored under key at any depth of v,
+// walking objects and arrays, in the original order of the parsed JSON.
+//
+// The returned values are valid until Parse is called on the Parser returned v.
+func (v *Value) SearchDeep(key string) []Match {
+	var matches []Match
+	v.searchDeep(key, nil, func(path []string, found *Value) {
+		matches = append(matches, Match{
+			Path:  append([]string(nil), path...),
+			Value: found,
+		})
+	})
+	return matches
+}
+
+// searchDeep calls f for each value stored under key at any depth of v.
+//
+// path is the keys path of v. f cannot hold path after returning.
+func (v *Value) searchDeep(key string, path []string, f func(path []string, found *Value)) {
+	switch v.t {
+	case TypeObject:
+		v.o.unescapeKeys()
+		for _, kv := range v.o.kvs {
+			path = append(path, kv.k)
+			if kv.k == key {
+				f(path, kv.v)
+			}
+			kv.v.searchDeep(key, path, f)
+			path = path[:len(path)-1]
+		}
+	case TypeArray:
+		for index, uValue := range v.a {
+			path = append(path, strconv.Itoa(index))
+			uValue.searchDeep(key, path, f)
+			path = path[:len(path)-1]
+		}
+	}
+}
+
+// deepArray returns an array of the values stored under key at any depth of v.
+func (v *Value) deepArray(key string) *Value {
+	a := &Value{t: TypeArray}
+	v.searchDeep(key, nil, func(_ []string, found *Value) {
+		a.a = append(a.a, found)
+	})
+	return a
+}
+
+// lookup returns the value of f in v, once selected by f.sel, and the
+// index of the first element selected in the array, if any.
+//
+// nil is returned for non-existing values.
+func (f field) lookup(v *Value) (*Value, int) {
+	var val *Value
+	if f.deep {
+		val = v.deepArray(f.path[0])
+	} else {
+		val = v.Get(f.path...)
+	}
+	if f.sel != nil {
+		return f.sel.apply(val)
+	}
+	return val, 0
+}
+
+// Search returns the values stored under the keys path in v, looking into
+// every item of the arrays met along the path.
+//
+// Strings, numbers and booleans are returned as string, float64 and bool,
+// null as nil. Objects found at the end of the path are returned as *Value.
+//
+// Missing keys fail with a *MissingKeysError. Use SearchMissing for
+// choosing another policy.
 func (v Value) Search(keys ...string) ([]interface{}, error) {
+	return v.SearchMissing(MissingStrict, keys...)
+}
+
+// SearchMissing is like Search, with missing keys handled according to
+// missing. Under MissingNull, a nil value stands for each missing key.
+func (v Value) SearchMissing(missing MissingPolicy, keys ...string) ([]interface{}, error) {
+	s := searcher{missing: missing}
+	rValues, err := s.search(&v, keys)
+	if err != nil {
+		return nil, err
+	}
+	if len(s.missingPaths) > 0 {
+		return nil, &MissingKeysError{Paths: s.missingPaths}
+	}
+	return rValues, nil
+}
+
+// searcher holds the state of SearchMissing.
+type searcher struct {
+	missing MissingPolicy
+
+	// path is the keys path of the value being searched.
+	path []string
+
+	missingPaths []string
+}
+
+func (s *searcher) search(v *Value, keys []string) ([]interface{}, error) {
 	var rValues []interface{}
 	switch v.Type() {
 	case TypeArray:
@@ -31,23 +147,37 @@ func (v Value) Search(keys ...string) ([]interface{}, error) {
 		if err != nil {
 			return nil, err
 		}
-		for _, uValue := range pValue {
-			nValue, err := uValue.Search(keys...)
+		for index, uValue := range pValue {
+			s.path = append(s.path, strconv.Itoa(index))
+			nValue, err := s.search(uValue, keys)
+			s.path = s.path[:len(s.path)-1]
 			if err != nil {
 				return nil, err
 			}
 			rValues = append(rValues, nValue...)
 		}
 	case TypeObject:
+		if len(keys) == 0 {
+			rValues = append(rValues, v)
+			break
+		}
 		pValue, err := v.Object()
 		if err != nil {
 			return nil, err
 		}
+		s.path = append(s.path, keys[0])
+		defer func() { s.path = s.path[:len(s.path)-1] }()
 		next := pValue.Get(keys[0])
 		if next == nil {
-			return nil, fmt.Errorf("key not found : %s", keys[0])
+			switch s.missing {
+			case MissingNull:
+				rValues = append(rValues, nil)
+			case MissingStrict:
+				s.missingPaths = append(s.missingPaths, strings.Join(s.path, "."))
+			}
+			return rValues, nil
 		}
-		nValue, err := pValue.Get(keys[0]).Search(keys[1:]...)
+		nValue, err := s.search(next, keys[1:])
 		if err != nil {
 			return nil, err
 		}
@@ -64,6 +194,8 @@ func (v Value) Search(keys ...string) ([]interface{}, error) {
 		rValues = append(rValues, false)
 	case TypeTrue:
 		rValues = append(rValues, true)
+	case TypeNull:
+		rValues = append(rValues, nil)
 	default:
 		return nil, fmt.Errorf("Type not recognized")
 	}
@@ -91,19 +223,21 @@ func (v Value) Check(request Query) error {
 			return err
 		}
 		if request.stillFilters {
-			for _, filter := range request.filters {
-				nValue := pValue.Get(filter.key)
-				if nValue != nil && nValue.check(*filter) == false {
-					return fmt.Errorf("")
-				}
+			if !checkFilters(pValue, request.filters) {
+				return fmt.Errorf("")
 			}
-			for name, next := range request.next {
-				nValue := pValue.Get(name)
-				if next != nil {
-					err := nValue.Check(Query(*next))
-					if err != nil {
-						return err
-					}
+			for _, f := range request.fields {
+				if f.query == nil {
+					continue
+				}
+				nValue, _ := f.lookup(&v)
+				if nValue == nil || f.deep && (f.sel == nil || f.sel.slice) && len(nValue.a) == 0 {
+					// Filters on missing values are unknown.
+					continue
+				}
+				err := nValue.Check(*f.query)
+				if err != nil {
+					return err
 				}
 			}
 		}
@@ -115,142 +249,362 @@ func (v Value) Check(request Query) error {
 	}
 }
 
+// Keep returns the parts of v selected by request.
+//
+// An empty string is returned if v is an object not matching the request
+// filters. Keys missing from v are handled according to the policy set
+// with Query.SetMissing.
 func (v Value) Keep(request Query) (string, error) {
-	w := bytes.Buffer{}
+	var w bytes.Buffer
+	if err := v.keepTo(&w, request); err != nil {
+		return "", err
+	}
+	return w.String(), nil
+}
+
+// KeepTo writes to w the parts of v selected by request.
+//
+// It writes the same output as Keep without building intermediate strings.
+// Part of the output may have been written to w when an error is returned.
+func (v Value) KeepTo(w io.Writer, request Query) error {
+	bw := bufio.NewWriter(w)
+	if err := v.keepTo(bw, request); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+func (v *Value) keepTo(w writer, request Query) error {
+	if !request.keeps(v) {
+		return nil
+	}
+	jw := jsonWriter{w: w, missing: request.missing}
+	if err := jw.keep(v, request); err != nil {
+		return err
+	}
+	return jw.missingError()
+}
+
+// Retrieve returns the parts of v selected by request, without applying
+// the request filters to v.
+func (v Value) Retrieve(request Query) (string, error) {
+	var w bytes.Buffer
+	if err := v.retrieveTo(&w, request); err != nil {
+		return "", err
+	}
+	return w.String(), nil
+}
+
+// RetrieveTo writes to w the parts of v selected by request.
+//
+// It writes the same output as Retrieve without building intermediate
+// strings. Part of the output may have been written to w when an error
+// is returned.
+func (v Value) RetrieveTo(w io.Writer, request Query) error {
+	bw := bufio.NewWriter(w)
+	if err := v.retrieveTo(bw, request); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+func (v *Value) retrieveTo(w writer, request Query) error {
+	jw := jsonWriter{w: w, missing: request.missing}
+	var err error
+	if v.Type() == TypeObject {
+		jw.beginObject()
+		err = jw.fields(v, request)
+		jw.endObject()
+	} else {
+		err = jw.keep(v, request)
+	}
+	if err != nil {
+		return err
+	}
+	return jw.missingError()
+}
+
+// keeps returns true if Keep outputs something for v, that is if v exists
+// and isn't an object failing the request filters.
+func (request Query) keeps(v *Value) bool {
+	if v == nil {
+		return false
+	}
+	return v.Type() != TypeObject || checkFilters(&v.o, request.filters)
+}
+
+// keep writes the parts of v selected by request, once request.keeps(v)
+// returned true.
+func (jw *jsonWriter) keep(v *Value, request Query) error {
 	switch v.Type() {
 	case TypeArray:
-		pValue, err := v.Array()
-		if err != nil {
-			return "", err
-		}
-		w.WriteRune('[')
-		for index, uValue := range pValue {
-			nValue, err := uValue.Keep(request)
-			if err != nil {
-				return "", err
+		base := jw.base
+		jw.base = 0
+		jw.beginArray()
+		if request.arranges() {
+			for _, index := range request.arrange(v.a) {
+				if err := jw.item(base+index, v.a[index], request); err != nil {
+					return err
+				}
 			}
-			if len(nValue) > 0 {
-				w.WriteString(nValue)
-				if index < len(pValue)-1 {
-					w.WriteRune(',')
+		} else {
+			for index, uValue := range v.a {
+				if !request.keeps(uValue) {
+					continue
+				}
+				if err := jw.item(base+index, uValue, request); err != nil {
+					return err
 				}
 			}
 		}
-		w.WriteRune(']')
-		return w.String(), nil
+		jw.endArray()
+		return nil
 	case TypeObject:
-		pValue, err := v.Object()
-		if err != nil {
-			return "", err
-		}
-		for _, filter := range request.filters {
-			if nValue := pValue.Get(filter.key); nValue != nil {
-				if nValue.check(*filter) == false {
-					return "", nil
-				}
-			}
+		jw.beginObject()
+		err := jw.fields(v, request)
+		jw.endObject()
+		return err
+	case TypeString, TypeNumber, TypeFalse, TypeTrue, TypeNull:
+		jw.value(v)
+		return nil
+	default:
+		return fmt.Errorf("Type not recognized")
+	}
+}
+
+// item writes the element v at index of an array, once request.keeps(v)
+// returned true.
+func (jw *jsonWriter) item(index int, v *Value, request Query) error {
+	if jw.missing == MissingStrict {
+		jw.path = append(jw.path, strconv.Itoa(index))
+	}
+	if err := jw.keep(v, request); err != nil {
+		return err
+	}
+	if jw.missing == MissingStrict {
+		jw.path = jw.path[:len(jw.path)-1]
+	}
+	return nil
+}
+
+// arrange returns the indexes of the elements of a kept by request, sorted
+// by request.order and paged by request.offset and request.limit.
+func (request Query) arrange(a []*Value) []int {
+	type element struct {
+		index int
+		keys  []*Value
+	}
+	elements := make([]element, 0, len(a))
+	for index, uValue := range a {
+		if !request.keeps(uValue) {
+			continue
 		}
-		w.WriteRune('{')
-		i := 0
-		for _, retrieve := range request.retrieve {
-			i++
-			w.WriteRune('"')
-			w.WriteString(retrieve)
-			w.WriteRune('"')
-			w.WriteRune(':')
-			w.WriteString(pValue.Get(retrieve).Description)
-			if i < len(request.next)+len(request.retrieve) {
-				w.WriteRune(',')
+		e := element{index: index}
+		if len(request.order) > 0 {
+			e.keys = make([]*Value, len(request.order))
+			for i, key := range request.order {
+				e.keys[i] = uValue.Get(key.path...)
 			}
 		}
-		for name, next := range request.next {
-			i++
-			nValue, err := pValue.Get(name).Keep(Query(*next))
-			if err != nil {
-				return "", err
-			}
-			w.WriteRune('"')
-			w.WriteString(name)
-			w.WriteRune('"')
-			w.WriteRune(':')
-			w.WriteString(nValue)
-			if i < len(request.next)+len(request.retrieve) {
-				w.WriteRune(',')
+		elements = append(elements, e)
+	}
+	if len(request.order) > 0 {
+		sort.SliceStable(elements, func(i, j int) bool {
+			for k, key := range request.order {
+				a, b := elements[i].keys[k], elements[j].keys[k]
+				// Missing values sort last, whatever the direction.
+				if a == nil || b == nil {
+					if a != b {
+						return b == nil
+					}
+					continue
+				}
+				if c := compareValues(a, b); c != 0 {
+					return (c < 0) != key.desc
+				}
 			}
+			return false
+		})
+	}
+	if request.offset >= len(elements) {
+		return nil
+	}
+	elements = elements[request.offset:]
+	if request.limited && request.limit < len(elements) {
+		elements = elements[:request.limit]
+	}
+	indexes := make([]int, len(elements))
+	for i, e := range elements {
+		indexes[i] = e.index
+	}
+	return indexes
+}
+
+// compareValues returns -1, 0 or 1 if a sorts before, with or after b.
+//
+// Numbers and strings sort by value. Values of different types sort in the
+// order null, false, true, numbers, strings, arrays and objects. Arrays and
+// objects are not compared.
+func compareValues(a, b *Value) int {
+	ra, rb := typeRank(a.Type()), typeRank(b.Type())
+	switch {
+	case ra < rb:
+		return -1
+	case ra > rb:
+		return 1
+	}
+	switch a.Type() {
+	case TypeNumber:
+		switch {
+		case a.n < b.n:
+			return -1
+		case a.n > b.n:
+			return 1
 		}
-		w.WriteRune('}')
-		return w.String(), nil
-	case TypeString, TypeNumber, TypeFalse, TypeTrue, TypeNull:
-		return v.Description, nil
-	default:
-		return "", fmt.Errorf("Type not recognized")
+	case TypeString:
+		return strings.Compare(a.s, b.s)
 	}
+	return 0
 }
 
-func (v Value) Retrieve(request Query) (string, error) {
-	w := bytes.Buffer{}
-	switch v.Type() {
+func typeRank(t Type) int {
+	switch t {
+	case TypeNull:
+		return 0
+	case TypeFalse:
+		return 1
+	case TypeTrue:
+		return 2
+	case TypeNumber:
+		return 3
+	case TypeString:
+		return 4
 	case TypeArray:
-		pValue, err := v.Array()
-		if err != nil {
-			return "", err
+		return 5
+	default:
+		return 6
+	}
+}
+
+// fields writes the members of the object v selected by request, in the
+// order of the query.
+//
+// Missing keys are handled according to jw.missing.
+func (jw *jsonWriter) fields(v *Value, request Query) error {
+	for index, f := range request.fields {
+		if index == request.allAt {
+			jw.wildcard(v, request)
 		}
-		w.WriteRune('[')
-		for index, uValue := range pValue {
-			nValue, err := uValue.Keep(request)
-			if err != nil {
-				return "", err
-			}
-			if len(nValue) > 0 {
-				w.WriteString(nValue)
-				if index < len(pValue)-1 {
-					w.WriteRune(',')
-				}
-			}
+		if err := jw.field(v, f); err != nil {
+			return err
 		}
-		w.WriteRune(']')
-		return w.String(), nil
-	case TypeObject:
-		pValue, err := v.Object()
-		if err != nil {
-			return "", err
+	}
+	if len(request.fields) <= request.allAt {
+		jw.wildcard(v, request)
+	}
+	return nil
+}
+
+// wildcard writes the members of the object v kept by the "*" of request.
+func (jw *jsonWriter) wildcard(v *Value, request Query) {
+	for _, kv := range request.wildcard(&v.o) {
+		jw.key(kv.k)
+		jw.value(kv.v)
+	}
+}
+
+// field writes the member f of the object v.
+func (jw *jsonWriter) field(v *Value, f field) error {
+	val, at := f.lookup(v)
+	if val == nil {
+		if jw.missingKey(f.keys()...) {
+			jw.key(f.name)
+			jw.value(nil)
 		}
-		w.WriteRune('{')
-		i := 0
-		for _, retrieve := range request.retrieve {
-			i++
-			val := pValue.Get(retrieve)
-			if val != nil {
-				w.WriteRune('"')
-				w.WriteString(retrieve)
-				w.WriteRune('"')
-				w.WriteRune(':')
-				w.WriteString(val.String())
-				if i < len(request.next)+len(request.retrieve) {
-					w.WriteRune(',')
-				}
-			}
+		return nil
+	}
+	if f.query == nil {
+		jw.key(f.name)
+		jw.value(val)
+		return nil
+	}
+	if !f.query.keeps(val) {
+		return nil
+	}
+	jw.key(f.name)
+	keys := f.keys()
+	if f.sel != nil && !f.deep {
+		// The elements selected are reported under their index in the
+		// document, as when there is no selector.
+		keys = f.path
+		if f.sel.slice {
+			jw.base = at
+		} else {
+			keys = append(keys[:len(keys):len(keys)], strconv.Itoa(at))
 		}
-		for name, next := range request.next {
-			i++
-			nValue, err := pValue.Get(name).Keep(Query(*next))
-			if err != nil {
-				return "", err
-			}
-			w.WriteRune('"')
-			w.WriteString(name)
-			w.WriteRune('"')
-			w.WriteRune(':')
-			w.WriteString(nValue)
-			if i < len(request.next)+len(request.retrieve) {
-				w.WriteRune(',')
-			}
+	}
+	jw.path = append(jw.path, keys...)
+	if err := jw.keep(val, *f.query); err != nil {
+		return err
+	}
+	jw.path = jw.path[:len(jw.path)-len(keys)]
+	return nil
+}
+
+// missingKey applies the missing keys policy to the keys path missing from
+// the current object. It returns true if null must be output for it.
+func (jw *jsonWriter) missingKey(path ...string) bool {
+	switch jw.missing {
+	case MissingNull:
+		return true
+	case MissingStrict:
+		p := append(jw.path[:len(jw.path):len(jw.path)], path...)
+		jw.missingPaths = append(jw.missingPaths, strings.Join(p, "."))
+	}
+	return false
+}
+
+// missingError returns a *MissingKeysError if keys were missing under
+// MissingStrict.
+func (jw *jsonWriter) missingError() error {
+	if len(jw.missingPaths) == 0 {
+		return nil
+	}
+	return &MissingKeysError{Paths: jw.missingPaths}
+}
+
+// wildcard returns the items of o kept by the "*" of request, in the
+// original order of the parsed JSON.
+//
+// Excluded keys and keys of the fields of request are skipped, so that
+// every key is output once.
+func (request Query) wildcard(o *Object) []kv {
+	if !request.all {
+		return nil
+	}
+	o.unescapeKeys()
+	kvs := make([]kv, 0, len(o.kvs))
+	for _, kv := range o.kvs {
+		if !request.claims(kv.k) {
+			kvs = append(kvs, kv)
+		}
+	}
+	return kvs
+}
+
+// claims returns true if key is excluded, explicitly kept by request or
+// used as an output name by request. Fields retrieving key under an alias
+// claim it, but not the deep ones, which don't stand for key itself.
+func (request Query) claims(key string) bool {
+	for _, exclude := range request.exclude {
+		if exclude == key {
+			return true
+		}
+	}
+	for _, f := range request.fields {
+		if f.name == key || !f.deep && len(f.path) == 1 && f.path[0] == key {
+			return true
 		}
-		w.WriteRune('}')
-		return w.String(), nil
-	case TypeString, TypeNumber, TypeFalse, TypeTrue, TypeNull:
-		return v.Description, nil
-	default:
-		return "", fmt.Errorf("Type not recognized")
 	}
+	return false
 }
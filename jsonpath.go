@@ -0,0 +1,865 @@
+package jsonq
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// JSONPath is a compiled JSONPath expression, as described in RFC 9535.
+//
+// The root identifier ($), child and descendant (..) segments, and name,
+// wildcard (*), index, slice (start:end:step) and filter (?) selectors are
+// supported. Function extensions aren't supported.
+//
+// JSONPath may be used from concurrent goroutines.
+type JSONPath struct {
+	expr     string
+	segments []pathSegment
+}
+
+// ParseJSONPath compiles the JSONPath expression expr.
+//
+// A *SyntaxError locating the problem is returned if expr is malformed.
+func ParseJSONPath(expr string) (*JSONPath, error) {
+	pp := pathParser{s: expr}
+	if !pp.consume("$") {
+		return nil, pp.errorf("expecting '$'")
+	}
+	segments, err := pp.parseSegments()
+	if err != nil {
+		return nil, err
+	}
+	if pp.pos < len(pp.s) {
+		return nil, pp.errorf("unexpected character")
+	}
+	return &JSONPath{expr: expr, segments: segments}, nil
+}
+
+// MustParseJSONPath is ParseJSONPath without error return. It panics if expr is malformed.
+func MustParseJSONPath(expr string) *JSONPath {
+	p, err := ParseJSONPath(expr)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// String returns the expression p was compiled from.
+func (p *JSONPath) String() string {
+	return p.expr
+}
+
+// Select returns the nodes of v selected by p, in the order defined by
+// RFC 9535.
+//
+// The returned values are valid until Parse is called on the Parser returned v.
+func (p *JSONPath) Select(v *Value) []*Value {
+	return selectSegments(p.segments, []*Value{v}, v)
+}
+
+type selectorKind int
+
+const (
+	selectName selectorKind = iota
+	selectWildcard
+	selectIndex
+	selectSlice
+	selectFilter
+)
+
+// pathSelector selects children of a node.
+type pathSelector struct {
+	kind selectorKind
+
+	name  string
+	index int
+
+	// start and end are only meaningful when hasStart and hasEnd are set.
+	start, end, step int
+	hasStart, hasEnd bool
+
+	filter *pathExpr
+}
+
+// pathSegment applies its selectors to a node, or to the node and all its
+// descendants when descendant is set.
+type pathSegment struct {
+	descendant bool
+	selectors  []pathSelector
+}
+
+// isSingular returns true if s selects at most one node.
+func (s pathSegment) isSingular() bool {
+	return !s.descendant && len(s.selectors) == 1 &&
+		(s.selectors[0].kind == selectName || s.selectors[0].kind == selectIndex)
+}
+
+func selectSegments(segments []pathSegment, nodes []*Value, root *Value) []*Value {
+	for _, segment := range segments {
+		var selected []*Value
+		for _, node := range nodes {
+			if segment.descendant {
+				node.visitDescendants(func(v *Value) {
+					selected = segment.apply(selected, v, root)
+				})
+			} else {
+				selected = segment.apply(selected, node, root)
+			}
+		}
+		nodes = selected
+	}
+	return nodes
+}
+
+// visitDescendants calls f for v and then for each of its descendants,
+// children being visited in the original order of the parsed JSON.
+func (v *Value) visitDescendants(f func(v *Value)) {
+	f(v)
+	switch v.t {
+	case TypeObject:
+		for _, kv := range v.o.kvs {
+			kv.v.visitDescendants(f)
+		}
+	case TypeArray:
+		for _, uValue := range v.a {
+			uValue.visitDescendants(f)
+		}
+	}
+}
+
+func (s pathSegment) apply(dst []*Value, v *Value, root *Value) []*Value {
+	for _, selector := range s.selectors {
+		dst = selector.apply(dst, v, root)
+	}
+	return dst
+}
+
+func (s pathSelector) apply(dst []*Value, v *Value, root *Value) []*Value {
+	switch s.kind {
+	case selectName:
+		if v.t == TypeObject {
+			if found := v.o.Get(s.name); found != nil {
+				dst = append(dst, found)
+			}
+		}
+	case selectWildcard:
+		switch v.t {
+		case TypeObject:
+			for _, kv := range v.o.kvs {
+				dst = append(dst, kv.v)
+			}
+		case TypeArray:
+			dst = append(dst, v.a...)
+		}
+	case selectIndex:
+		if v.t == TypeArray {
+			index := s.index
+			if index < 0 {
+				index += len(v.a)
+			}
+			if index >= 0 && index < len(v.a) {
+				dst = append(dst, v.a[index])
+			}
+		}
+	case selectSlice:
+		if v.t == TypeArray {
+			dst = s.slice(dst, v.a)
+		}
+	case selectFilter:
+		switch v.t {
+		case TypeObject:
+			for _, kv := range v.o.kvs {
+				if s.filter.eval(kv.v, root) {
+					dst = append(dst, kv.v)
+				}
+			}
+		case TypeArray:
+			for _, uValue := range v.a {
+				if s.filter.eval(uValue, root) {
+					dst = append(dst, uValue)
+				}
+			}
+		}
+	}
+	return dst
+}
+
+// slice appends the elements of a selected by the slice selector s,
+// following the algorithm of RFC 9535 section 2.3.4.2.2.
+func (s pathSelector) slice(dst []*Value, a []*Value) []*Value {
+	n := len(a)
+	if s.step == 0 {
+		return dst
+	}
+	normalize := func(i int) int {
+		if i < 0 {
+			return n + i
+		}
+		return i
+	}
+	clamp := func(i, lower, upper int) int {
+		if i < lower {
+			return lower
+		}
+		if i > upper {
+			return upper
+		}
+		return i
+	}
+	if s.step > 0 {
+		start, end := 0, n
+		if s.hasStart {
+			start = clamp(normalize(s.start), 0, n)
+		}
+		if s.hasEnd {
+			end = clamp(normalize(s.end), 0, n)
+		}
+		for i := start; i < end; i += s.step {
+			dst = append(dst, a[i])
+		}
+		return dst
+	}
+	start, end := n-1, -1
+	if s.hasStart {
+		start = clamp(normalize(s.start), -1, n-1)
+	}
+	if s.hasEnd {
+		end = clamp(normalize(s.end), -1, n-1)
+	}
+	for i := start; i > end; i += s.step {
+		dst = append(dst, a[i])
+	}
+	return dst
+}
+
+type exprKind int
+
+const (
+	exprOr exprKind = iota
+	exprAnd
+	exprNot
+	exprExists
+	exprCompare
+)
+
+// pathExpr is a node of a filter expression.
+type pathExpr struct {
+	kind exprKind
+
+	// sub holds the operands of or, and and not nodes.
+	sub []*pathExpr
+
+	// query is the tested query of exists nodes.
+	query *pathQuery
+
+	// op, left and right describe compare nodes.
+	op          Operation
+	left, right pathOperand
+}
+
+// pathQuery is a query embedded in a filter expression. It is relative
+// to the current node (@) unless absolute is set ($).
+type pathQuery struct {
+	absolute bool
+	segments []pathSegment
+}
+
+func (q *pathQuery) selectNodes(current, root *Value) []*Value {
+	if q.absolute {
+		current = root
+	}
+	return selectSegments(q.segments, []*Value{current}, root)
+}
+
+// pathOperand is a comparable: either a literal or a singular query.
+type pathOperand struct {
+	literal *Value
+	query   *pathQuery
+}
+
+// value returns the value of o. nil is returned when the query of o
+// doesn't select any node.
+func (o pathOperand) value(current, root *Value) *Value {
+	if o.query == nil {
+		return o.literal
+	}
+	nodes := o.query.selectNodes(current, root)
+	if len(nodes) == 0 {
+		return nil
+	}
+	return nodes[0]
+}
+
+func (e *pathExpr) eval(current, root *Value) bool {
+	switch e.kind {
+	case exprOr:
+		for _, sub := range e.sub {
+			if sub.eval(current, root) {
+				return true
+			}
+		}
+		return false
+	case exprAnd:
+		for _, sub := range e.sub {
+			if !sub.eval(current, root) {
+				return false
+			}
+		}
+		return true
+	case exprNot:
+		return !e.sub[0].eval(current, root)
+	case exprExists:
+		return len(e.query.selectNodes(current, root)) > 0
+	default:
+		return comparePathValues(e.op, e.left.value(current, root), e.right.value(current, root))
+	}
+}
+
+// comparePathValues returns the result of `left op right`, following the
+// comparison rules of RFC 9535: nil stands for an empty nodelist, != is the
+// negation of == and only numbers and strings are ordered.
+func comparePathValues(op Operation, left, right *Value) bool {
+	switch op {
+	case eq:
+		return pathValuesEqual(left, right)
+	case diff:
+		return !pathValuesEqual(left, right)
+	case inf, sup:
+		if left == nil || right == nil {
+			return false
+		}
+		lt, rt := left.Type(), right.Type()
+		if lt != rt || (lt != TypeNumber && lt != TypeString) {
+			return false
+		}
+		return op.check(right.comparable(), left.comparable())
+	case infEq:
+		return comparePathValues(inf, left, right) || pathValuesEqual(left, right)
+	case supEq:
+		return comparePathValues(sup, left, right) || pathValuesEqual(left, right)
+	default:
+		return false
+	}
+}
+
+func pathValuesEqual(left, right *Value) bool {
+	if left == nil || right == nil {
+		return left == nil && right == nil
+	}
+	lt, rt := left.Type(), right.Type()
+	switch {
+	case lt == TypeObject && rt == TypeObject:
+		if left.o.Len() != right.o.Len() {
+			return false
+		}
+		left.o.unescapeKeys()
+		for _, kv := range left.o.kvs {
+			if !pathValuesEqual(kv.v, right.o.Get(kv.k)) {
+				return false
+			}
+		}
+		return true
+	case lt == TypeArray && rt == TypeArray:
+		if len(left.a) != len(right.a) {
+			return false
+		}
+		for index := range left.a {
+			if !pathValuesEqual(left.a[index], right.a[index]) {
+				return false
+			}
+		}
+		return true
+	case lt == TypeObject || lt == TypeArray || rt == TypeObject || rt == TypeArray:
+		return false
+	default:
+		return eq.check(right.comparable(), left.comparable())
+	}
+}
+
+// comparable returns the Go value Operation.check compares for the
+// scalar v.
+func (v *Value) comparable() interface{} {
+	switch v.Type() {
+	case TypeString:
+		return v.s
+	case TypeNumber:
+		return v.n
+	case TypeTrue:
+		return true
+	case TypeFalse:
+		return false
+	default:
+		return nil
+	}
+}
+
+// pathParser is a recursive descent parser for JSONPath expressions.
+type pathParser struct {
+	s   string
+	pos int
+}
+
+func (pp *pathParser) errorf(format string, args ...interface{}) error {
+	t := token{offset: pp.pos}
+	if pp.pos < len(pp.s) {
+		_, n := utf8.DecodeRuneInString(pp.s[pp.pos:])
+		t.text = pp.s[pp.pos : pp.pos+n]
+	}
+	return newSyntaxError(pp.s, t, format, args...)
+}
+
+func (pp *pathParser) skipWS() {
+	for pp.pos < len(pp.s) && strings.IndexByte(wsChars, pp.s[pp.pos]) >= 0 {
+		pp.pos++
+	}
+}
+
+func (pp *pathParser) peekByte() byte {
+	if pp.pos < len(pp.s) {
+		return pp.s[pp.pos]
+	}
+	return 0
+}
+
+// consume skips prefix if the remaining input starts with it.
+func (pp *pathParser) consume(prefix string) bool {
+	if strings.HasPrefix(pp.s[pp.pos:], prefix) {
+		pp.pos += len(prefix)
+		return true
+	}
+	return false
+}
+
+// parseSegments parses segments until a character which can't start
+// a segment.
+func (pp *pathParser) parseSegments() ([]pathSegment, error) {
+	var segments []pathSegment
+	for {
+		start := pp.pos
+		pp.skipWS()
+		var segment pathSegment
+		switch {
+		case pp.consume(".."):
+			segment.descendant = true
+			if pp.peekByte() == '[' {
+				selectors, err := pp.parseBracketed()
+				if err != nil {
+					return nil, err
+				}
+				segment.selectors = selectors
+			} else {
+				selector, err := pp.parseDotted()
+				if err != nil {
+					return nil, err
+				}
+				segment.selectors = []pathSelector{selector}
+			}
+		case pp.consume("."):
+			selector, err := pp.parseDotted()
+			if err != nil {
+				return nil, err
+			}
+			segment.selectors = []pathSelector{selector}
+		case pp.peekByte() == '[':
+			selectors, err := pp.parseBracketed()
+			if err != nil {
+				return nil, err
+			}
+			segment.selectors = selectors
+		default:
+			pp.pos = start
+			return segments, nil
+		}
+		segments = append(segments, segment)
+	}
+}
+
+// parseDotted parses the wildcard or the member name following a dot.
+func (pp *pathParser) parseDotted() (pathSelector, error) {
+	if pp.consume("*") {
+		return pathSelector{kind: selectWildcard}, nil
+	}
+	start := pp.pos
+	for pp.pos < len(pp.s) {
+		r, n := utf8.DecodeRuneInString(pp.s[pp.pos:])
+		isAlpha := r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r == '_' || r >= 0x80
+		if !isAlpha && !(pp.pos > start && r >= '0' && r <= '9') {
+			break
+		}
+		pp.pos += n
+	}
+	if pp.pos == start {
+		return pathSelector{}, pp.errorf("expecting member name")
+	}
+	return pathSelector{kind: selectName, name: pp.s[start:pp.pos]}, nil
+}
+
+// parseBracketed parses a comma separated list of selectors enclosed
+// in brackets.
+func (pp *pathParser) parseBracketed() ([]pathSelector, error) {
+	pp.pos++
+	var selectors []pathSelector
+	for {
+		pp.skipWS()
+		selector, err := pp.parseSelector()
+		if err != nil {
+			return nil, err
+		}
+		selectors = append(selectors, selector)
+		pp.skipWS()
+		if pp.consume("]") {
+			return selectors, nil
+		}
+		if !pp.consume(",") {
+			return nil, pp.errorf("expecting ',' or ']'")
+		}
+	}
+}
+
+func (pp *pathParser) parseSelector() (pathSelector, error) {
+	c := pp.peekByte()
+	switch {
+	case c == '\'' || c == '"':
+		name, err := pp.parseString()
+		if err != nil {
+			return pathSelector{}, err
+		}
+		return pathSelector{kind: selectName, name: name}, nil
+	case c == '*':
+		pp.pos++
+		return pathSelector{kind: selectWildcard}, nil
+	case c == '?':
+		pp.pos++
+		filter, err := pp.parseOr()
+		if err != nil {
+			return pathSelector{}, err
+		}
+		return pathSelector{kind: selectFilter, filter: filter}, nil
+	case c == ':' || c == '-' || c >= '0' && c <= '9':
+		return pp.parseIndexOrSlice()
+	default:
+		return pathSelector{}, pp.errorf("expecting selector")
+	}
+}
+
+func (pp *pathParser) parseIndexOrSlice() (pathSelector, error) {
+	s := pathSelector{kind: selectIndex, step: 1}
+	var hasIndex bool
+	var err error
+	if pp.peekByte() != ':' {
+		if s.index, err = pp.parseInt(); err != nil {
+			return s, err
+		}
+		hasIndex = true
+	}
+	pp.skipWS()
+	if !pp.consume(":") {
+		return s, nil
+	}
+	s.kind = selectSlice
+	s.start, s.hasStart = s.index, hasIndex
+	pp.skipWS()
+	if c := pp.peekByte(); c == '-' || c >= '0' && c <= '9' {
+		if s.end, err = pp.parseInt(); err != nil {
+			return s, err
+		}
+		s.hasEnd = true
+		pp.skipWS()
+	}
+	if pp.consume(":") {
+		pp.skipWS()
+		if c := pp.peekByte(); c == '-' || c >= '0' && c <= '9' {
+			if s.step, err = pp.parseInt(); err != nil {
+				return s, err
+			}
+		}
+	}
+	return s, nil
+}
+
+// maxPathInt is the largest integer allowed in indexes and slices.
+const maxPathInt = 1<<53 - 1
+
+func (pp *pathParser) parseInt() (int, error) {
+	start := pp.pos
+	pp.consume("-")
+	for pp.pos < len(pp.s) && pp.s[pp.pos] >= '0' && pp.s[pp.pos] <= '9' {
+		pp.pos++
+	}
+	s := pp.s[start:pp.pos]
+	if s == "-0" || len(s) > 1 && s[0] == '0' || len(s) > 2 && s[:2] == "-0" {
+		pp.pos = start
+		return 0, pp.errorf("invalid integer")
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n > maxPathInt || n < -maxPathInt {
+		pp.pos = start
+		return 0, pp.errorf("invalid integer")
+	}
+	return int(n), nil
+}
+
+// parseString parses a string literal enclosed in single or double quotes.
+func (pp *pathParser) parseString() (string, error) {
+	quote := pp.s[pp.pos]
+	start := pp.pos
+	pp.pos++
+	for pp.pos < len(pp.s) {
+		switch pp.s[pp.pos] {
+		case quote:
+			pp.pos++
+			s, err := unquoteString(pp.s[start+1:pp.pos-1], quote)
+			if err != nil {
+				pp.pos = start
+				return "", pp.errorf("%s", err)
+			}
+			return s, nil
+		case '\\':
+			pp.pos += 2
+		default:
+			pp.pos++
+		}
+	}
+	pp.pos = start
+	return "", pp.errorf("missing closing %q", quote)
+}
+
+// unquoteString decodes the escape sequences of the string literal s
+// enclosed in quote, as described by the JSON grammar. quote may also
+// be escaped.
+func unquoteString(s string, quote byte) (string, error) {
+	b := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == quote {
+			return "", fmt.Errorf("unescaped %q in string", quote)
+		}
+		if c < 0x20 {
+			return "", fmt.Errorf("control character %q in string", c)
+		}
+		if c != '\\' {
+			b = append(b, c)
+			continue
+		}
+		i++
+		if i >= len(s) {
+			return "", fmt.Errorf("unterminated escape sequence")
+		}
+		switch s[i] {
+		case 'b':
+			b = append(b, '\b')
+		case 'f':
+			b = append(b, '\f')
+		case 'n':
+			b = append(b, '\n')
+		case 'r':
+			b = append(b, '\r')
+		case 't':
+			b = append(b, '\t')
+		case '/', '\\':
+			b = append(b, s[i])
+		case 'u':
+			r, size, err := unquoteRune(s[i+1:])
+			if err != nil {
+				return "", err
+			}
+			b = append(b, string(r)...)
+			i += size
+		default:
+			if s[i] != quote {
+				return "", fmt.Errorf("invalid escape sequence %q", s[i-1:i+1])
+			}
+			b = append(b, quote)
+		}
+	}
+	return string(b), nil
+}
+
+// unquoteRune decodes the hexadecimal digits following a \u escape
+// sequence, including a low surrogate escape sequence if required.
+// It returns the rune and the number of bytes read.
+func unquoteRune(s string) (rune, int, error) {
+	if len(s) < 4 {
+		return 0, 0, fmt.Errorf("too short \\u escape sequence")
+	}
+	x, err := strconv.ParseUint(s[:4], 16, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid \\u escape sequence %q", s[:4])
+	}
+	r := rune(x)
+	if !utf16.IsSurrogate(r) {
+		return r, 4, nil
+	}
+	if len(s) < 10 || s[4:6] != `\u` {
+		return 0, 0, fmt.Errorf("missing low surrogate after \\u%s", s[:4])
+	}
+	y, err := strconv.ParseUint(s[6:10], 16, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid \\u escape sequence %q", s[6:10])
+	}
+	r = utf16.DecodeRune(r, rune(y))
+	if r == utf8.RuneError {
+		return 0, 0, fmt.Errorf("invalid surrogate pair \\u%s\\u%s", s[:4], s[6:10])
+	}
+	return r, 10, nil
+}
+
+// parseOr parses a logical expression:
+//
+//	or      = and { "||" and }
+//	and     = basic { "&&" basic }
+//	basic   = [ "!" ] "(" or ")" | [ "!" ] query | comparable op comparable
+func (pp *pathParser) parseOr() (*pathExpr, error) {
+	return pp.parseLogical(exprOr, "||", pp.parseAnd)
+}
+
+func (pp *pathParser) parseAnd() (*pathExpr, error) {
+	return pp.parseLogical(exprAnd, "&&", pp.parseBasic)
+}
+
+func (pp *pathParser) parseLogical(kind exprKind, op string, operand func() (*pathExpr, error)) (*pathExpr, error) {
+	first, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	e := &pathExpr{kind: kind, sub: []*pathExpr{first}}
+	for {
+		pp.skipWS()
+		if !pp.consume(op) {
+			break
+		}
+		next, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		e.sub = append(e.sub, next)
+	}
+	if len(e.sub) == 1 {
+		return first, nil
+	}
+	return e, nil
+}
+
+func (pp *pathParser) parseBasic() (*pathExpr, error) {
+	pp.skipWS()
+	negate := pp.peekByte() == '!' && !strings.HasPrefix(pp.s[pp.pos:], "!=")
+	if negate {
+		pp.pos++
+		pp.skipWS()
+	}
+	var e *pathExpr
+	if pp.consume("(") {
+		sub, err := pp.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		pp.skipWS()
+		if !pp.consume(")") {
+			return nil, pp.errorf("expecting ')'")
+		}
+		e = sub
+	} else {
+		start := pp.pos
+		left, err := pp.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		pp.skipWS()
+		opStart := pp.pos
+		op, isComparison := pp.parseComparisonOp()
+		switch {
+		case isComparison && !negate:
+			right, err := pp.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			for _, operand := range []pathOperand{left, right} {
+				if operand.query != nil && !operand.query.isSingular() {
+					pp.pos = start
+					return nil, pp.errorf("non-singular query in comparison")
+				}
+			}
+			e = &pathExpr{kind: exprCompare, op: op, left: left, right: right}
+		case isComparison:
+			pp.pos = opStart
+			return nil, pp.errorf("comparison can't be negated without parentheses")
+		case left.query == nil:
+			pp.pos = start
+			return nil, pp.errorf("literal must be compared")
+		default:
+			e = &pathExpr{kind: exprExists, query: left.query}
+		}
+	}
+	if negate {
+		e = &pathExpr{kind: exprNot, sub: []*pathExpr{e}}
+	}
+	return e, nil
+}
+
+func (q *pathQuery) isSingular() bool {
+	for _, segment := range q.segments {
+		if !segment.isSingular() {
+			return false
+		}
+	}
+	return true
+}
+
+// parseComparisonOp parses a comparison operator and returns the Operation
+// with the same meaning.
+func (pp *pathParser) parseComparisonOp() (Operation, bool) {
+	for _, op := range []struct {
+		text string
+		op   Operation
+	}{
+		{"==", eq}, {"!=", diff}, {"<=", infEq}, {">=", supEq}, {"<", inf}, {">", sup},
+	} {
+		if pp.consume(op.text) {
+			pp.skipWS()
+			return op.op, true
+		}
+	}
+	return "", false
+}
+
+func (pp *pathParser) parseOperand() (pathOperand, error) {
+	pp.skipWS()
+	c := pp.peekByte()
+	switch {
+	case c == '@' || c == '$':
+		pp.pos++
+		segments, err := pp.parseSegments()
+		if err != nil {
+			return pathOperand{}, err
+		}
+		return pathOperand{query: &pathQuery{absolute: c == '$', segments: segments}}, nil
+	case c == '\'' || c == '"':
+		s, err := pp.parseString()
+		if err != nil {
+			return pathOperand{}, err
+		}
+		return pathOperand{literal: &Value{t: TypeString, s: s}}, nil
+	case pp.consume("true"):
+		return pathOperand{literal: valueTrue}, nil
+	case pp.consume("false"):
+		return pathOperand{literal: valueFalse}, nil
+	case pp.consume("null"):
+		return pathOperand{literal: valueNull}, nil
+	case c == '-' || c >= '0' && c <= '9':
+		ns, tail, err := parseRawNumber(pp.s[pp.pos:])
+		if err != nil {
+			return pathOperand{}, pp.errorf("invalid number")
+		}
+		f, err := strconv.ParseFloat(ns, 64)
+		if err != nil {
+			return pathOperand{}, pp.errorf("invalid number")
+		}
+		pp.pos = len(pp.s) - len(tail)
+		return pathOperand{literal: &Value{t: TypeNumber, n: f}}, nil
+	default:
+		return pathOperand{}, pp.errorf("expecting query or literal")
+	}
+}
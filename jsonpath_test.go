@@ -0,0 +1,144 @@
+package jsonq
+
+import (
+	"strings"
+	"testing"
+)
+
+const storeFixture = `{ "store": {
+    "book": [
+      { "category": "reference",
+        "author": "Nigel Rees",
+        "title": "Sayings of the Century",
+        "price": 8.95
+      },
+      { "category": "fiction",
+        "author": "Evelyn Waugh",
+        "title": "Sword of Honour",
+        "price": 12.99
+      },
+      { "category": "fiction",
+        "author": "Herman Melville",
+        "title": "Moby Dick",
+        "isbn": "0-553-21311-3",
+        "price": 8.99
+      },
+      { "category": "fiction",
+        "author": "J. R. R. Tolkien",
+        "title": "The Lord of the Rings",
+        "isbn": "0-395-19395-8",
+        "price": 22.99
+      }
+    ],
+    "bicycle": {
+      "color": "red",
+      "price": 399
+    }
+  }
+}`
+
+func TestJSONPath(t *testing.T) {
+	var p Parser
+	v, err := p.Parse(storeFixture)
+	if err != nil {
+		t.Fatalf("cannot parse json: %s", err)
+	}
+	f := func(expr string, expected ...string) {
+		t.Helper()
+		path, err := ParseJSONPath(expr)
+		if err != nil {
+			t.Fatalf("unexpected error when parsing %q: %s", expr, err)
+		}
+		var got []string
+		for _, node := range path.Select(v) {
+			got = append(got, node.String())
+		}
+		if strings.Join(got, "|") != strings.Join(expected, "|") {
+			t.Fatalf("unexpected nodes selected by %q; got %q; want %q", expr, got, expected)
+		}
+	}
+
+	authors := []string{`"Nigel Rees"`, `"Evelyn Waugh"`, `"Herman Melville"`, `"J. R. R. Tolkien"`}
+	f(`$.store.book[*].author`, authors...)
+	f(`$..author`, authors...)
+	f(`$["store"]['book'][*]["author"]`, authors...)
+	f(`$.store.*.color`, `"red"`)
+	f(`$.store..price`, "8.950000", "12.990000", "8.990000", "22.990000", "399")
+	f(`$..book[2].title`, `"Moby Dick"`)
+	f(`$..book[-1].title`, `"The Lord of the Rings"`)
+	f(`$..book[0,1].title`, `"Sayings of the Century"`, `"Sword of Honour"`)
+	f(`$..book[:2].title`, `"Sayings of the Century"`, `"Sword of Honour"`)
+	f(`$..book[1:3].title`, `"Sword of Honour"`, `"Moby Dick"`)
+	f(`$..book[::2].title`, `"Sayings of the Century"`, `"Moby Dick"`)
+	f(`$..book[::-1].title`, `"The Lord of the Rings"`, `"Moby Dick"`, `"Sword of Honour"`, `"Sayings of the Century"`)
+	f(`$..book[-2:].title`, `"Moby Dick"`, `"The Lord of the Rings"`)
+	f(`$..book[10].title`)
+	f(`$..book[?@.isbn].title`, `"Moby Dick"`, `"The Lord of the Rings"`)
+	f(`$..book[?(!@.isbn)].title`, `"Sayings of the Century"`, `"Sword of Honour"`)
+	f(`$..book[?(@.price < 10)].title`, `"Sayings of the Century"`, `"Moby Dick"`)
+	f(`$..book[?@.price <= 8.99].title`, `"Sayings of the Century"`, `"Moby Dick"`)
+	f(`$..book[?@.price > $.store.bicycle.price].title`)
+	f(`$..book[?@.category == 'fiction' && @.price > 20].title`, `"The Lord of the Rings"`)
+	f(`$..book[?(@.author == "Nigel Rees" || @.price >= 22.99)].title`, `"Sayings of the Century"`, `"The Lord of the Rings"`)
+	f(`$..book[?@.isbn != '0-553-21311-3'].title`, `"Sayings of the Century"`, `"Sword of Honour"`, `"The Lord of the Rings"`)
+	f(`$..book[?@.price < 'a'].title`)
+	f(`$.store.bicycle[?@ == 'red']`, `"red"`)
+	f(`$..*[?@.color]`, `{"color":"red","price":399}`)
+	f(`$.store[?@.color].price`, "399")
+	f(`$`, v.String())
+}
+
+func TestJSONPathError(t *testing.T) {
+	f := func(expr string, expectedColumn int) {
+		t.Helper()
+		_, err := ParseJSONPath(expr)
+		if err == nil {
+			t.Fatalf("expecting non-nil error when parsing %q", expr)
+		}
+		se, ok := err.(*SyntaxError)
+		if !ok {
+			t.Fatalf("unexpected error type for %q; got %T; want *SyntaxError", expr, err)
+		}
+		if se.Column != expectedColumn {
+			t.Fatalf("unexpected error column for %q; got %d; want %d (%s)", expr, se.Column, expectedColumn, se)
+		}
+	}
+	f(``, 1)
+	f(`store`, 1)
+	f(`$.`, 3)
+	f(`$.1a`, 3)
+	f(`$[`, 3)
+	f(`$[1`, 4)
+	f(`$['a`, 3)
+	f(`$[01]`, 3)
+	f(`$[?@.a == 1`, 12)
+	f(`$[?!@.a == 1]`, 9)
+	f(`$[?@..a == 1]`, 4)
+	f(`$[?1]`, 4)
+	f(`$['\q']`, 3)
+	f(`$.a b`, 4)
+}
+
+func TestUnquoteString(t *testing.T) {
+	f := func(s string, quote byte, expected string) {
+		t.Helper()
+		got, err := unquoteString(s, quote)
+		if err != nil {
+			t.Fatalf("unexpected error when unquoting %q: %s", s, err)
+		}
+		if got != expected {
+			t.Fatalf("unexpected string; got %q; want %q", got, expected)
+		}
+	}
+	f(`foo`, '"', "foo")
+	f(`a\"b\\c\/d`, '"', `a"b\c/d`)
+	f(`it\'s`, '\'', "it's")
+	f(`\b\f\n\r\t`, '"', "\b\f\n\r\t")
+	f(`é𝄞`, '"', "é\U0001D11E")
+
+	for _, s := range []string{`a"b`, `\x`, `\u12`, `\uD834`, "a\nb", `\`} {
+		if _, err := unquoteString(s, '"'); err == nil {
+			t.Fatalf("expecting non-nil error when unquoting %q", s)
+		}
+	}
+}
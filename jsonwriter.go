@@ -0,0 +1,108 @@
+package jsonq
+
+import (
+	"io"
+)
+
+// writer is the output of KeepTo and RetrieveTo.
+//
+// It is implemented by bytes.Buffer and bufio.Writer.
+type writer interface {
+	io.Writer
+	WriteByte(c byte) error
+	WriteString(s string) (int, error)
+}
+
+// jsonWriter writes JSON to w, inserting the commas between the elements
+// of arrays and objects.
+type jsonWriter struct {
+	w writer
+
+	// frames holds the arrays and objects being written, innermost last.
+	frames []jsonFrame
+
+	// buf is reused for marshaling values.
+	buf []byte
+
+	// missing is the policy applied to missing keys by fields.
+	missing MissingPolicy
+
+	// path is the keys path of the value being kept. Array indexes are
+	// only tracked under MissingStrict.
+	path []string
+
+	// base is the index in the document of the first element of the next
+	// array kept, when it is a slice of a larger array.
+	base int
+
+	// missingPaths holds the keys paths missing under MissingStrict.
+	missingPaths []string
+}
+
+type jsonFrame struct {
+	object bool
+
+	// first is true until an element is written.
+	first bool
+}
+
+// separate writes a comma unless no element of the innermost container
+// was written yet.
+func (jw *jsonWriter) separate() {
+	n := len(jw.frames)
+	if n == 0 {
+		return
+	}
+	if jw.frames[n-1].first {
+		jw.frames[n-1].first = false
+		return
+	}
+	jw.w.WriteByte(',')
+}
+
+// element starts a value, which follows a key inside objects.
+func (jw *jsonWriter) element() {
+	if n := len(jw.frames); n > 0 && !jw.frames[n-1].object {
+		jw.separate()
+	}
+}
+
+func (jw *jsonWriter) beginObject() {
+	jw.element()
+	jw.w.WriteByte('{')
+	jw.frames = append(jw.frames, jsonFrame{object: true, first: true})
+}
+
+func (jw *jsonWriter) endObject() {
+	jw.frames = jw.frames[:len(jw.frames)-1]
+	jw.w.WriteByte('}')
+}
+
+func (jw *jsonWriter) beginArray() {
+	jw.element()
+	jw.w.WriteByte('[')
+	jw.frames = append(jw.frames, jsonFrame{first: true})
+}
+
+func (jw *jsonWriter) endArray() {
+	jw.frames = jw.frames[:len(jw.frames)-1]
+	jw.w.WriteByte(']')
+}
+
+// key writes the key of the next object member.
+func (jw *jsonWriter) key(name string) {
+	jw.separate()
+	jw.buf = appendQuotedString(jw.buf[:0], name)
+	jw.buf = append(jw.buf, ':')
+	jw.w.Write(jw.buf)
+}
+
+// value writes v as compact JSON, or null if v is nil.
+func (jw *jsonWriter) value(v *Value) {
+	if v == nil {
+		v = valueNull
+	}
+	jw.element()
+	jw.buf = v.MarshalTo(jw.buf[:0])
+	jw.w.Write(jw.buf)
+}
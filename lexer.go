@@ -0,0 +1,304 @@
+package jsonq
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// SyntaxError is returned by ParseQuery when a query is malformed.
+type SyntaxError struct {
+	// Msg describes the problem.
+	Msg string
+
+	// Token is the offending token, or the character found where a value or
+	// a key was expected. It is empty only at the end of the query.
+	Token string
+
+	// Offset is the byte offset of Token in the query.
+	Offset int
+
+	// Line and Column locate Token in the query. Both start at 1 and
+	// Column is counted in runes.
+	Line   int
+	Column int
+}
+
+// Error implements the error interface.
+func (e *SyntaxError) Error() string {
+	if len(e.Token) == 0 {
+		return fmt.Sprintf("%d:%d: %s at end of query", e.Line, e.Column, e.Msg)
+	}
+	return fmt.Sprintf("%d:%d: %s near %q", e.Line, e.Column, e.Msg, e.Token)
+}
+
+func newSyntaxError(query string, t token, format string, args ...interface{}) *SyntaxError {
+	line, column := 1, 1
+	for _, r := range query[:t.offset] {
+		if r == '\n' {
+			line++
+			column = 1
+		} else {
+			column++
+		}
+	}
+	text := t.text
+	if len(text) == 0 && t.offset < len(query) {
+		// An empty value or key stops at the character which follows it:
+		// report that character, since the query doesn't end here.
+		_, n := utf8.DecodeRuneInString(query[t.offset:])
+		text = query[t.offset : t.offset+n]
+	}
+	return &SyntaxError{
+		Msg:    fmt.Sprintf(format, args...),
+		Token:  text,
+		Offset: t.offset,
+		Line:   line,
+		Column: column,
+	}
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenWord
+	tokenString
+	tokenOperation
+	tokenAnd
+	tokenOr
+	tokenPunct
+	tokenInvalid
+)
+
+func (k tokenKind) String() string {
+	switch k {
+	case tokenEOF:
+		return "end of query"
+	case tokenWord:
+		return "key"
+	case tokenString:
+		return "string"
+	case tokenOperation:
+		return "operation"
+	case tokenAnd:
+		return "'&&'"
+	case tokenOr:
+		return "'||'"
+	case tokenPunct:
+		return "punctuation"
+	default:
+		return "invalid token"
+	}
+}
+
+// token is a lexical token of a query.
+type token struct {
+	kind tokenKind
+
+	// text is the token as written in the query.
+	text string
+
+	// offset is the byte offset of the token in the query.
+	offset int
+}
+
+func (t token) is(kind tokenKind, text string) bool {
+	return t.kind == kind && t.text == text
+}
+
+const (
+	punctChars     = "{}()[],"
+	operationChars = "<>!:="
+	wsChars        = " \t\r\n"
+)
+
+// operations lists the operation tokens, longest first.
+var operations = []string{
+	"!::=", "!::", "::=", "!:=",
+	"!=", "<=", ">=", "!:", "::", ":=",
+	"=", "<", ">", ":", "!",
+}
+
+// lexer splits a query into tokens.
+//
+// Tokens are read on demand, since values in filters follow different rules
+// than the rest of the query: see nextValue.
+type lexer struct {
+	s   string
+	pos int
+
+	// peeked holds the token returned by peek, if any.
+	peeked *token
+}
+
+func (lx *lexer) skipWS() {
+	for lx.pos < len(lx.s) && strings.IndexByte(wsChars, lx.s[lx.pos]) >= 0 {
+		lx.pos++
+	}
+}
+
+// span skips the longest run of bytes accepted by f and returns it.
+func (lx *lexer) span(f func(c byte) bool) string {
+	start := lx.pos
+	for lx.pos < len(lx.s) && f(lx.s[lx.pos]) {
+		lx.pos++
+	}
+	return lx.s[start:lx.pos]
+}
+
+func (lx *lexer) peek() token {
+	if lx.peeked == nil {
+		t := lx.next()
+		lx.peeked = &t
+	}
+	return *lx.peeked
+}
+
+func (lx *lexer) next() token {
+	if lx.peeked != nil {
+		t := *lx.peeked
+		lx.peeked = nil
+		return t
+	}
+	lx.skipWS()
+	t := token{offset: lx.pos}
+	if lx.pos >= len(lx.s) {
+		t.kind = tokenEOF
+		return t
+	}
+	c := lx.s[lx.pos]
+	switch {
+	case strings.IndexByte(punctChars, c) >= 0:
+		t.kind = tokenPunct
+		lx.pos++
+	case strings.IndexByte(operationChars, c) >= 0:
+		lx.operation(&t)
+	case strings.HasPrefix(lx.s[lx.pos:], "&&"):
+		t.kind = tokenAnd
+		lx.pos += 2
+	case strings.HasPrefix(lx.s[lx.pos:], "||"):
+		t.kind = tokenOr
+		lx.pos += 2
+	case c == '"':
+		lx.quoted(&t)
+	case c == '&' || c == '|':
+		t.kind = tokenInvalid
+		_, n := utf8.DecodeRuneInString(lx.s[lx.pos:])
+		lx.pos += n
+	default:
+		t.kind = tokenWord
+		lx.span(func(c byte) bool {
+			return strings.IndexByte(punctChars+operationChars+wsChars+`&|"`, c) < 0
+		})
+	}
+	t.text = lx.s[t.offset:lx.pos]
+	return t
+}
+
+// operation reads the operation starting at the current position into t.
+// An operation directly followed by another operation character is
+// invalid, and t is then that character.
+func (lx *lexer) operation(t *token) {
+	for _, op := range operations {
+		if strings.HasPrefix(lx.s[lx.pos:], op) {
+			t.kind = tokenOperation
+			lx.pos += len(op)
+			break
+		}
+	}
+	if lx.pos < len(lx.s) && strings.IndexByte(operationChars, lx.s[lx.pos]) >= 0 {
+		t.kind = tokenInvalid
+		t.offset = lx.pos
+		lx.pos++
+	}
+}
+
+// quoted reads the string enclosed in double quotes starting at the
+// current position into t. Escaped quotes don't end the string.
+func (lx *lexer) quoted(t *token) {
+	for i := lx.pos + 1; i < len(lx.s); i++ {
+		switch lx.s[i] {
+		case '\\':
+			i++
+		case '"':
+			t.kind = tokenString
+			lx.pos = i + 1
+			return
+		}
+	}
+	t.kind = tokenInvalid
+	lx.pos = len(lx.s)
+}
+
+// rewind moves back to offset, dropping the peeked token.
+func (lx *lexer) rewind(offset int) {
+	lx.pos = offset
+	lx.peeked = nil
+}
+
+// nextKey returns the next token as a key of a keys path. Whitespaces are
+// not skipped.
+//
+// A key is either a string enclosed in double quotes or a run of bytes
+// which may be part of a word, dots excepted.
+func (lx *lexer) nextKey() token {
+	t := token{kind: tokenWord, offset: lx.pos}
+	if lx.pos < len(lx.s) && lx.s[lx.pos] == '"' {
+		lx.quoted(&t)
+	} else {
+		lx.span(func(c byte) bool {
+			return strings.IndexByte(punctChars+operationChars+wsChars+`&|".`, c) < 0
+		})
+	}
+	t.text = lx.s[t.offset:lx.pos]
+	return t
+}
+
+// skipDot skips the dot at the current position, if any, and returns true
+// if it did.
+func (lx *lexer) skipDot() bool {
+	if lx.pos < len(lx.s) && lx.s[lx.pos] == '.' {
+		lx.pos++
+		return true
+	}
+	return false
+}
+
+// nextValue returns the next token as a filter value.
+//
+// A value is either a string enclosed in double quotes or a run of bytes
+// up to a whitespace, '&', '|', '"', ',', a parenthesis or a brace.
+func (lx *lexer) nextValue() token {
+	return lx.value(`&|(){},"` + wsChars)
+}
+
+// nextListValue returns the next token as an item of a list of filter
+// values. Items also end at '[' and ']'.
+func (lx *lexer) nextListValue() token {
+	return lx.value(`&|(){}[],"` + wsChars)
+}
+
+// value returns the next token as a value ending at one of stopChars.
+func (lx *lexer) value(stopChars string) token {
+	if lx.peeked != nil {
+		// Values are never peeked, so start again from the peeked token.
+		lx.pos = lx.peeked.offset
+		lx.peeked = nil
+	}
+	lx.skipWS()
+	t := token{kind: tokenWord, offset: lx.pos}
+	if lx.pos >= len(lx.s) {
+		t.kind = tokenEOF
+		return t
+	}
+	if lx.s[lx.pos] == '"' {
+		lx.quoted(&t)
+	} else {
+		lx.span(func(c byte) bool {
+			return strings.IndexByte(stopChars, c) < 0
+		})
+	}
+	t.text = lx.s[t.offset:lx.pos]
+	return t
+}
@@ -0,0 +1,250 @@
+package jsonq
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// MarshalTo appends compact JSON representation of v to dst and returns
+// the result.
+//
+// Numbers are written with their original text, so no precision is lost.
+func (v *Value) MarshalTo(dst []byte) []byte {
+	m := marshaler{}
+	return m.value(dst, v, 0)
+}
+
+// MarshalIndentTo appends indented JSON representation of v to dst and
+// returns the result.
+//
+// Each JSON element begins on a new line starting with prefix followed by
+// one or more copies of indent according to the nesting depth, like
+// json.MarshalIndent does.
+func (v *Value) MarshalIndentTo(dst []byte, prefix, indent string) []byte {
+	m := marshaler{indented: true, prefix: prefix, indent: indent}
+	return m.value(dst, v, 0)
+}
+
+// MarshalCanonicalTo appends the canonical JSON representation of v to dst,
+// as described in RFC 8785, and returns the result.
+//
+// Object keys are sorted by their UTF-16 code units and numbers are
+// serialized like ECMAScript does. Non-finite numbers have no canonical
+// representation and are written with their original text.
+func (v *Value) MarshalCanonicalTo(dst []byte) []byte {
+	m := marshaler{canonical: true}
+	return m.value(dst, v, 0)
+}
+
+// marshaler holds the options of the MarshalTo family.
+type marshaler struct {
+	indented  bool
+	prefix    string
+	indent    string
+	canonical bool
+}
+
+func (m *marshaler) newline(dst []byte, depth int) []byte {
+	if !m.indented {
+		return dst
+	}
+	dst = append(dst, '\n')
+	dst = append(dst, m.prefix...)
+	for i := 0; i < depth; i++ {
+		dst = append(dst, m.indent...)
+	}
+	return dst
+}
+
+func (m *marshaler) value(dst []byte, v *Value, depth int) []byte {
+	switch v.Type() {
+	case TypeObject:
+		return m.object(dst, &v.o, depth)
+	case TypeArray:
+		if len(v.a) == 0 {
+			return append(dst, "[]"...)
+		}
+		dst = append(dst, '[')
+		for i, vv := range v.a {
+			if i > 0 {
+				dst = append(dst, ',')
+			}
+			dst = m.newline(dst, depth+1)
+			dst = m.value(dst, vv, depth+1)
+		}
+		dst = m.newline(dst, depth)
+		return append(dst, ']')
+	case TypeString:
+		return appendQuotedString(dst, v.s)
+	case TypeNumber:
+		if m.canonical {
+			return appendCanonicalNumber(dst, v)
+		}
+		if isValidNumber(v.s) {
+			return append(dst, v.s...)
+		}
+		return strconv.AppendFloat(dst, v.n, 'g', -1, 64)
+	case TypeTrue:
+		return append(dst, "true"...)
+	case TypeFalse:
+		return append(dst, "false"...)
+	case TypeNull:
+		return append(dst, "null"...)
+	default:
+		panic(fmt.Errorf("BUG: unknown Value type: %d", v.Type()))
+	}
+}
+
+func (m *marshaler) object(dst []byte, o *Object, depth int) []byte {
+	o.unescapeKeys()
+	if len(o.kvs) == 0 {
+		return append(dst, "{}"...)
+	}
+	kvs := o.kvs
+	if m.canonical {
+		kvs = append([]kv(nil), kvs...)
+		sort.SliceStable(kvs, func(i, j int) bool {
+			return lessUTF16(kvs[i].k, kvs[j].k)
+		})
+	}
+	dst = append(dst, '{')
+	for i, kv := range kvs {
+		if i > 0 {
+			dst = append(dst, ',')
+		}
+		dst = m.newline(dst, depth+1)
+		dst = appendQuotedString(dst, kv.k)
+		dst = append(dst, ':')
+		if m.indented {
+			dst = append(dst, ' ')
+		}
+		dst = m.value(dst, kv.v, depth+1)
+	}
+	dst = m.newline(dst, depth)
+	return append(dst, '}')
+}
+
+const hexDigits = "0123456789abcdef"
+
+// appendQuotedString appends s to dst as a JSON string.
+//
+// Only '"', '\\' and control characters are escaped, as RFC 8785 requires.
+// Invalid UTF-8 sequences are replaced by U+FFFD.
+func appendQuotedString(dst []byte, s string) []byte {
+	dst = append(dst, '"')
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c >= utf8.RuneSelf {
+			r, n := utf8.DecodeRuneInString(s[i:])
+			if r == utf8.RuneError && n == 1 {
+				dst = append(dst, "\ufffd"...)
+			} else {
+				dst = append(dst, s[i:i+n]...)
+			}
+			i += n
+			continue
+		}
+		switch c {
+		case '"', '\\':
+			dst = append(dst, '\\', c)
+		case '\b':
+			dst = append(dst, '\\', 'b')
+		case '\f':
+			dst = append(dst, '\\', 'f')
+		case '\n':
+			dst = append(dst, '\\', 'n')
+		case '\r':
+			dst = append(dst, '\\', 'r')
+		case '\t':
+			dst = append(dst, '\\', 't')
+		default:
+			if c < 0x20 {
+				dst = append(dst, '\\', 'u', '0', '0', hexDigits[c>>4], hexDigits[c&0xf])
+			} else {
+				dst = append(dst, c)
+			}
+		}
+		i++
+	}
+	return append(dst, '"')
+}
+
+// appendCanonicalNumber appends v to dst the way ECMAScript's
+// Number.prototype.toString does.
+func appendCanonicalNumber(dst []byte, v *Value) []byte {
+	f := v.n
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return append(dst, v.s...)
+	}
+	if f == 0 {
+		// Both 0 and -0 are written as 0.
+		return append(dst, '0')
+	}
+	abs := math.Abs(f)
+	if abs >= 1e-6 && abs < 1e21 {
+		return strconv.AppendFloat(dst, f, 'f', -1, 64)
+	}
+	n := len(dst)
+	dst = strconv.AppendFloat(dst, f, 'e', -1, 64)
+	// Turn e-07 into e-7, since ECMAScript has no leading zeros in exponents.
+	if len(dst)-n >= 4 && dst[len(dst)-4] == 'e' && dst[len(dst)-2] == '0' {
+		dst[len(dst)-2] = dst[len(dst)-1]
+		dst = dst[:len(dst)-1]
+	}
+	return dst
+}
+
+// isValidNumber returns true if s is a number as defined by RFC 8259.
+func isValidNumber(s string) bool {
+	if len(s) > 0 && s[0] == '-' {
+		s = s[1:]
+	}
+	if len(s) == 0 {
+		return false
+	}
+	digits := func() int {
+		n := 0
+		for n < len(s) && s[n] >= '0' && s[n] <= '9' {
+			n++
+		}
+		s = s[n:]
+		return n
+	}
+	if s[0] == '0' {
+		s = s[1:]
+	} else if digits() == 0 {
+		return false
+	}
+	if len(s) > 0 && s[0] == '.' {
+		s = s[1:]
+		if digits() == 0 {
+			return false
+		}
+	}
+	if len(s) > 0 && (s[0] == 'e' || s[0] == 'E') {
+		s = s[1:]
+		if len(s) > 0 && (s[0] == '+' || s[0] == '-') {
+			s = s[1:]
+		}
+		if digits() == 0 {
+			return false
+		}
+	}
+	return len(s) == 0
+}
+
+// lessUTF16 compares a and b by their UTF-16 code units.
+func lessUTF16(a, b string) bool {
+	ua := utf16.Encode([]rune(a))
+	ub := utf16.Encode([]rune(b))
+	for i := 0; i < len(ua) && i < len(ub); i++ {
+		if ua[i] != ub[i] {
+			return ua[i] < ub[i]
+		}
+	}
+	return len(ua) < len(ub)
+}
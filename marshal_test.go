@@ -0,0 +1,116 @@
+package jsonq
+
+import (
+	"testing"
+)
+
+func TestMarshalTo(t *testing.T) {
+	f := func(s, expected string) {
+		t.Helper()
+		var p Parser
+		v, err := p.Parse(s)
+		if err != nil {
+			t.Fatalf("cannot parse json %q: %s", s, err)
+		}
+		got := string(v.MarshalTo(nil))
+		if got != expected {
+			t.Fatalf("unexpected json for %q; got %s; want %s", s, got, expected)
+		}
+
+		// The output must be parsed back to the same output.
+		var p2 Parser
+		v2, err := p2.Parse(got)
+		if err != nil {
+			t.Fatalf("cannot parse marshaled json %q: %s", got, err)
+		}
+		if again := string(v2.MarshalTo(nil)); again != got {
+			t.Fatalf("unexpected json after round trip; got %s; want %s", again, got)
+		}
+	}
+	f(`null`, `null`)
+	f(` [ true , false ] `, `[true,false]`)
+	f(`{}`, `{}`)
+	f(`[]`, `[]`)
+	f(`12345678901234567890`, `12345678901234567890`)
+	f(`1.10`, `1.10`)
+	f(`-1.5E+3`, `-1.5E+3`)
+	f(`"a\"b\\c\/d\u0001\t\u2028é"`, "\"a\\\"b\\\\c/d\\u0001\\t\u2028é\"")
+	f(`"\ud83d\ude00"`, "\"\U0001F600\"")
+	f(`{"a\nb": [1, {"c": 0.1}], "d": "e"}`, `{"a\nb":[1,{"c":0.1}],"d":"e"}`)
+
+	// Numbers which aren't valid JSON are written from their value.
+	f(`[01, -012]`, `[1,-12]`)
+
+	// The original buffer isn't modified.
+	dst := []byte("prefix:")
+	var p Parser
+	v, err := p.Parse(`{"x": 1}`)
+	if err != nil {
+		t.Fatalf("cannot parse json: %s", err)
+	}
+	if got := string(v.MarshalTo(dst)); got != `prefix:{"x":1}` {
+		t.Fatalf("unexpected json; got %s", got)
+	}
+}
+
+func TestMarshalIndentTo(t *testing.T) {
+	var p Parser
+	v, err := p.Parse(`{"a": [1, "b", {}], "c": {"d": null, "e": []}}`)
+	if err != nil {
+		t.Fatalf("cannot parse json: %s", err)
+	}
+	expected := `{
+>  "a": [
+>    1,
+>    "b",
+>    {}
+>  ],
+>  "c": {
+>    "d": null,
+>    "e": []
+>  }
+>}`
+	if got := string(v.MarshalIndentTo(nil, ">", "  ")); got != expected {
+		t.Fatalf("unexpected json; got\n%s\nwant\n%s", got, expected)
+	}
+	v, err = p.Parse(`1`)
+	if err != nil {
+		t.Fatalf("cannot parse json: %s", err)
+	}
+	if got := string(v.MarshalIndentTo(nil, ">", "  ")); got != `1` {
+		t.Fatalf("unexpected json; got %s; want 1", got)
+	}
+}
+
+func TestMarshalCanonicalTo(t *testing.T) {
+	f := func(s, expected string) {
+		t.Helper()
+		var p Parser
+		v, err := p.Parse(s)
+		if err != nil {
+			t.Fatalf("cannot parse json %q: %s", s, err)
+		}
+		if got := string(v.MarshalCanonicalTo(nil)); got != expected {
+			t.Fatalf("unexpected canonical json for %q; got %s; want %s", s, got, expected)
+		}
+	}
+
+	// Examples from RFC 8785.
+	f(`{
+		"numbers": [333333333.33333329, 1E30, 4.50, 2e-3, 0.000000000000000000000000001],
+		"string": "\u20ac$\u000F\u000aA'\u0042\u0022\u005c\\\"\/",
+		"literals": [null, true, false]
+	}`, `{"literals":[null,true,false],"numbers":[333333333.3333333,1e+30,4.5,0.002,1e-27],"string":"€$\u000f\nA'B\"\\\\\"/"}`)
+	f(`{
+		"\u20ac": "Euro Sign",
+		"\r": "Carriage Return",
+		"\ufb33": "Hebrew Letter Dalet With Dagesh",
+		"1": "One",
+		"\ud83d\ude00": "Emoji: Grinning Face",
+		"\u0080": "Control",
+		"\u00f6": "Latin Small Letter O With Diaeresis"
+	}`, "{\"\\r\":\"Carriage Return\",\"1\":\"One\",\"\u0080\":\"Control\",\"ö\":\"Latin Small Letter O With Diaeresis\",\"€\":\"Euro Sign\",\"\U0001F600\":\"Emoji: Grinning Face\",\"\ufb33\":\"Hebrew Letter Dalet With Dagesh\"}")
+
+	f(`[0, -0, 1e21, 1e20, 1e-6, 1e-7, -5e-324, 1.7976931348623157e308]`,
+		`[0,0,1e+21,100000000000000000000,0.000001,1e-7,-5e-324,1.7976931348623157e+308]`)
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
 )
 
 // Parser parses JSON.
@@ -125,19 +127,19 @@ func parseValue(s string, c *cache) (*Value, string, error) {
 	}
 	if s[0] == 't' {
 		if len(s) < len("true") || s[:len("true")] != "true" {
-			return nil, s, fmt.Errorf("unexpected value found: %q", s)
+			return nil, s, fmt.Errorf("unexpected value found: %q", startOf(s))
 		}
 		return valueTrue, s[len("true"):], nil
 	}
 	if s[0] == 'f' {
 		if len(s) < len("false") || s[:len("false")] != "false" {
-			return nil, s, fmt.Errorf("unexpected value found: %q", s)
+			return nil, s, fmt.Errorf("unexpected value found: %q", startOf(s))
 		}
 		return valueFalse, s[len("false"):], nil
 	}
 	if s[0] == 'n' {
 		if len(s) < len("null") || s[:len("null")] != "null" {
-			return nil, s, fmt.Errorf("unexpected value found: %q", s)
+			return nil, s, fmt.Errorf("unexpected value found: %q", startOf(s))
 		}
 		return valueNull, s[len("null"):], nil
 	}
@@ -153,6 +155,16 @@ func parseValue(s string, c *cache) (*Value, string, error) {
 	return v, tail, nil
 }
 
+// startOf returns the beginning of s for error messages, so that they stay
+// short whatever the length of the unparsed input.
+func startOf(s string) string {
+	const maxLen = 32
+	if len(s) <= maxLen {
+		return s
+	}
+	return s[:maxLen] + "..."
+}
+
 func parseArray(s string, c *cache) (*Value, string, error) {
 	s = skipWS(s)
 	if len(s) == 0 {
@@ -288,8 +300,18 @@ func unescapeStringBestEffort(s string) string {
 				b = append(b, '\\', ch)
 				break
 			}
-			b = append(b, string(rune(x))...)
 			s = s[4:]
+			r := rune(x)
+			if utf16.IsSurrogate(r) && len(s) >= 6 && s[0] == '\\' && s[1] == 'u' {
+				// Decode surrogate pair, such as \ud83d\ude00.
+				if y, err := strconv.ParseUint(s[2:6], 16, 16); err == nil {
+					if rr := utf16.DecodeRune(r, rune(y)); rr != utf8.RuneError {
+						r = rr
+						s = s[6:]
+					}
+				}
+			}
+			b = append(b, string(r)...)
 		default:
 			// Unknown escape sequence. Just store it unchanged.
 			b = append(b, '\\', ch)
@@ -389,6 +411,7 @@ func (o *Object) reset() {
 // String returns string representation for the o.
 //
 // This function is for debugging purposes only. It isn't optimized for speed.
+// Use Value.MarshalTo for obtaining valid JSON.
 func (o *Object) String() string {
 	o.unescapeKeys()
 
@@ -502,7 +525,8 @@ func (v *Value) reset() {
 // The function is for debugging purposes only. It isn't optimized for speed.
 //
 // Don't confuse this function with StringBytes, which must be called
-// for obtaining the underlying JSON string for the v.
+// for obtaining the underlying JSON string for the v. Use MarshalTo
+// for obtaining valid JSON.
 func (v *Value) String() string {
 	switch v.Type() {
 	case TypeObject:
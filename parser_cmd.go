@@ -18,14 +18,43 @@ const (
 	notContain Operation = "!:"
 	like       Operation = "::"
 	notLike    Operation = "!::"
-)
 
-var cmdRegex = regexp.MustCompile(`^([a-z_]+)?(?:\(([^{\}\)\(]*)\))?(?:{(.*)})?$`)
-var filterRegex = regexp.MustCompile(`(?:([a-zA-Z_-]+)\s*([><!:=]+)\s*((?:[^&\(\)\{}\s\")]+|(?:\"[^&\(\)\{}]*\")))\s*)+`)
+	containCase    Operation = ":="
+	notContainCase Operation = "!:="
+	likeCase       Operation = "::="
+	notLikeCase    Operation = "!::="
+
+	in    Operation = "in"
+	notIn Operation = "not in"
+
+	between    Operation = "between"
+	notBetween Operation = "not between"
+
+	has Operation = "has"
+
+	and Operation = "&&"
+	or  Operation = "||"
+	not Operation = "!"
+)
 
 // Operation is common possible operations in filters (=, !=, >, <, >=, <=, :).
+//
+// The contain (:) and like (::) operations, and their negations, ignore
+// case. Their variants ending with '=' (:=, !:=, ::=, !::=) are
+// case-sensitive.
+//
+// in and not in check whether a value is equal to one of a list, such as
+// `status in ["open", "pending"]`.
+//
+// between and not between check whether a number or a string is within a
+// range, such as `price between [10..50)`, where '[' and ']' are inclusive
+// bounds, '(' and ')' exclusive ones. in and not in also accept a range.
 type Operation string
 
+// check compares base, the value of a filter, with compared.
+//
+// The like operations are not handled, since Filter.check matches their
+// pattern compiled once.
 func (o Operation) check(base, compared interface{}) bool {
 	switch o {
 	case eq:
@@ -44,10 +73,18 @@ func (o Operation) check(base, compared interface{}) bool {
 		return checkContain(base, compared)
 	case notContain:
 		return checkNotContain(base, compared)
-	case like:
-		return checkLike(base, compared)
-	case notLike:
-		return checkNotLike(base, compared)
+	case containCase:
+		return checkContainCase(base, compared)
+	case notContainCase:
+		return checkNotContainCase(base, compared)
+	case in:
+		return checkIn(base, compared)
+	case notIn:
+		return checkNotIn(base, compared)
+	case between:
+		return checkBetween(base, compared)
+	case notBetween:
+		return checkNotBetween(base, compared)
 	default:
 		return false
 	}
@@ -75,6 +112,22 @@ func findOperation(line string) (Operation, error) {
 		return like, nil
 	case "!::":
 		return notLike, nil
+	case ":=":
+		return containCase, nil
+	case "!:=":
+		return notContainCase, nil
+	case "::=":
+		return likeCase, nil
+	case "!::=":
+		return notLikeCase, nil
+	case "in":
+		return in, nil
+	case "not in":
+		return notIn, nil
+	case "between":
+		return between, nil
+	case "not between":
+		return notBetween, nil
 	default:
 		return "error", fmt.Errorf("operation %s does not exist", line)
 	}
@@ -82,6 +135,8 @@ func findOperation(line string) (Operation, error) {
 
 func checkEq(base, compared interface{}) bool {
 	switch v := base.(type) {
+	case nil:
+		return compared == nil
 	case bool:
 		if comp, ok := compared.(bool); ok == true {
 			return comp == v
@@ -119,6 +174,8 @@ func checkEq(base, compared interface{}) bool {
 
 func checkDiff(base, compared interface{}) bool {
 	switch v := base.(type) {
+	case nil:
+		return compared != nil
 	case bool:
 		if comp, ok := compared.(bool); ok == true {
 			return comp != v
@@ -208,7 +265,7 @@ func checkSupEq(base, compared interface{}) bool {
 		}
 		return false
 	case string:
-		if comp, ok := compared.(string); ok == true && comp > v {
+		if comp, ok := compared.(string); ok == true && comp >= v {
 			return true
 		}
 		return false
@@ -277,7 +334,7 @@ func checkInfEq(base, compared interface{}) bool {
 		}
 		return false
 	case string:
-		if comp, ok := compared.(string); ok == true && v < comp {
+		if comp, ok := compared.(string); ok == true && comp <= v {
 			return true
 		}
 		return false
@@ -292,82 +349,269 @@ func checkInfEq(base, compared interface{}) bool {
 	return false
 }
 
-// In this case we check if the compare string is contained int the base string
-func checkContain(base, compared interface{}) bool {
-	if b, ok := base.(string); ok == true {
-		if c, ok := compared.(string); ok == true {
-			b = strings.ToLower(b)
-			b = strings.TrimLeft(b, `"`)
-			b = strings.TrimRight(b, `"`)
-			c = strings.ToLower(c)
-			return strings.Contains(c, b)
+// checkIn returns true if compared is equal to one of the items of the
+// list base.
+func checkIn(base, compared interface{}) bool {
+	if list, ok := base.([]interface{}); ok == true {
+		for _, item := range list {
+			if checkEq(item, compared) {
+				return true
+			}
 		}
 	}
 	return false
 }
 
-func checkNotContain(base, compared interface{}) bool {
-	if b, ok := base.(string); ok == true {
-		if c, ok := compared.(string); ok == true {
-			b = strings.ToLower(b)
-			b = strings.TrimLeft(b, `"`)
-			b = strings.TrimRight(b, `"`)
-			c = strings.ToLower(c)
-			return !strings.Contains(c, b)
-		}
+func checkNotIn(base, compared interface{}) bool {
+	if list, ok := base.([]interface{}); ok == true {
+		return !checkIn(list, compared)
 	}
 	return false
 }
 
-// In this function base was the json value, compared the string used for the regex. Both should be strings
-func checkLike(base, compared interface{}) bool {
-	if b, ok := base.(string); ok == true {
-		if c, ok := compared.(string); ok == true {
-			b = strings.ToLower(b)
-			b = strings.TrimLeft(b, `"`)
-			b = strings.TrimRight(b, `"`)
-			c = strings.ToLower(c)
-			if ok, err := regexp.MatchString(b, c); err == nil {
-				return ok
-			}
-		}
+// valueRange is the range of values of the between operations, such as
+// [10..50).
+//
+// Both bounds are either numbers or strings.
+type valueRange struct {
+	low, high         interface{}
+	lowOpen, highOpen bool
+}
+
+// String returns string representation of r.
+func (r valueRange) String() string {
+	left, right := "[", "]"
+	if r.lowOpen {
+		left = "("
 	}
-	return false
+	if r.highOpen {
+		right = ")"
+	}
+	return left + formatValue(r.low) + ".." + formatValue(r.high) + right
 }
 
-func checkNotLike(base, compared interface{}) bool {
-	if b, ok := base.(string); ok == true {
-		if c, ok := compared.(string); ok == true {
-			b = strings.ToLower(b)
-			b = strings.TrimLeft(b, `"`)
-			b = strings.TrimRight(b, `"`)
-			c = strings.ToLower(c)
-			if ok, err := regexp.MatchString(b, c); err == nil {
-				return !ok
-			}
-		}
+// checkBetween returns true if compared is within the range base.
+func checkBetween(base, compared interface{}) bool {
+	r, ok := base.(valueRange)
+	if !ok {
+		return false
 	}
-	return false
+	if r.lowOpen && !checkSup(r.low, compared) || !r.lowOpen && !checkSupEq(r.low, compared) {
+		return false
+	}
+	if r.highOpen {
+		return checkInf(r.high, compared)
+	}
+	return checkInfEq(r.high, compared)
+}
+
+func checkNotBetween(base, compared interface{}) bool {
+	r, ok := base.(valueRange)
+	if !ok {
+		return false
+	}
+	// Values which can't be compared with the bounds aren't out of range.
+	comparable := checkSupEq(r.low, compared) || checkInf(r.low, compared)
+	return comparable && !checkBetween(r, compared)
+}
+
+// stringOperands returns base and compared as strings, lowercased when
+// fold is set. ok is false if either isn't a string.
+func stringOperands(base, compared interface{}, fold bool) (b, c string, ok bool) {
+	b, ok = base.(string)
+	if !ok {
+		return "", "", false
+	}
+	c, ok = compared.(string)
+	if !ok {
+		return "", "", false
+	}
+	if fold {
+		b = strings.ToLower(b)
+		c = strings.ToLower(c)
+	}
+	return b, c, true
+}
+
+// In this case we check if the compare string is contained int the base string.
+// Case is ignored.
+func checkContain(base, compared interface{}) bool {
+	b, c, ok := stringOperands(base, compared, true)
+	return ok && strings.Contains(c, b)
+}
+
+func checkNotContain(base, compared interface{}) bool {
+	b, c, ok := stringOperands(base, compared, true)
+	return ok && !strings.Contains(c, b)
+}
+
+// checkContainCase is checkContain, with case taken into account.
+func checkContainCase(base, compared interface{}) bool {
+	b, c, ok := stringOperands(base, compared, false)
+	return ok && strings.Contains(c, b)
+}
+
+func checkNotContainCase(base, compared interface{}) bool {
+	b, c, ok := stringOperands(base, compared, false)
+	return ok && !strings.Contains(c, b)
 }
 
 //Filter is the type used for describe a operation of filtering
+//
+// A Filter is either a comparison of the value of key with val, or a
+// group (&&, ||, !) of the sub filters.
+//
+// key may be a dot separated path to a nested value, such as
+// `author.profile.country`. Array indexes may be represented as decimal
+// numbers in the path.
+//
+// The pattern of the like operations is compiled once in re.
+//
+// has filters are true when key exists. typeOf filters compare the type
+// name of the value of key, as returned by Type.String, instead of the
+// value itself.
 type Filter struct {
-	key string
-	op  Operation
-	val interface{}
+	key    string
+	path   []string
+	op     Operation
+	val    interface{}
+	sub    []*Filter
+	re     *regexp.Regexp
+	typeOf bool
+}
+
+// newFilter returns the comparison of the value of key with the value
+// written text in the query, which is a string if quoted is set.
+//
+// An error is returned if op is a like operation and text isn't a valid
+// regular expression.
+func newFilter(key string, path []string, op Operation, text string, quoted bool) (*Filter, error) {
+	f := &Filter{key: key, path: path, op: op, val: literal(text, quoted)}
+	switch op {
+	case like, notLike, likeCase, notLikeCase:
+		// The user's pattern is compiled first, so that errors don't show
+		// the (?i) flag.
+		re, err := regexp.Compile(text)
+		if err != nil {
+			return nil, err
+		}
+		if op == like || op == notLike {
+			re = regexp.MustCompile("(?i)" + text)
+		}
+		f.re = re
+	}
+	return f, nil
 }
 
 func (f Filter) eq(other Filter) bool {
 	bkey := f.key == other.key
 	bop := f.op == other.op
-	bval := fmt.Sprintln(f.val) == fmt.Sprintln(other.val)
-	return bkey && bop && bval
+	bval := fmt.Sprintf("%#v", f.val) == fmt.Sprintf("%#v", other.val)
+	if len(f.sub) != len(other.sub) {
+		return false
+	}
+	for index, sub := range f.sub {
+		if !sub.eq(*other.sub[index]) {
+			return false
+		}
+	}
+	return bkey && bop && bval && f.typeOf == other.typeOf
 }
 
 func (f Filter) check(compareTo interface{}) bool {
+	if f.re != nil {
+		s, ok := compareTo.(string)
+		if !ok {
+			return false
+		}
+		return f.re.MatchString(s) != (f.op == notLike || f.op == notLikeCase)
+	}
 	return f.op.check(f.val, compareTo)
 }
 
+// String returns string representation of f.
+func (f Filter) String() string {
+	key := f.key
+	if f.typeOf {
+		key = "type(" + key + ")"
+	}
+	switch f.op {
+	case not:
+		return fmt.Sprintf("!(%s)", f.sub[0])
+	case and, or:
+		parts := make([]string, 0, len(f.sub))
+		for _, sub := range f.sub {
+			parts = append(parts, sub.String())
+		}
+		return "(" + strings.Join(parts, " "+string(f.op)+" ") + ")"
+	case has:
+		return fmt.Sprintf("has(%s)", f.key)
+	case between, notBetween:
+		return fmt.Sprintf("%s %s %v", key, f.op, f.val)
+	case in, notIn:
+		items := make([]string, 0, len(f.val.([]interface{})))
+		for _, item := range f.val.([]interface{}) {
+			items = append(items, formatValue(item))
+		}
+		return fmt.Sprintf("%s %s [%s]", key, f.op, strings.Join(items, ", "))
+	default:
+		return fmt.Sprintf("%s %s %s", key, f.op, formatValue(f.val))
+	}
+}
+
+// eval evaluates f against the object o.
+//
+// Comparisons on keys missing from o are ignored: known is false for them,
+// and they are skipped by the groups they belong to. Type predicates are
+// the exception: a missing key has no type, so it matches neither = nor in,
+// and matches != and not in.
+func (f Filter) eval(o *Object) (ok, known bool) {
+	switch f.op {
+	case not:
+		ok, known = f.sub[0].eval(o)
+		return !ok, known
+	case and, or:
+		// The result of a group is the one of its first decisive sub filter.
+		// && is decided by false and || by true.
+		decisive := f.op == or
+		for _, sub := range f.sub {
+			ok, subKnown := sub.eval(o)
+			if !subKnown {
+				continue
+			}
+			if ok == decisive {
+				return decisive, true
+			}
+			known = true
+		}
+		return !decisive, known
+	case has:
+		return getPath(o, f.path) != nil, true
+	default:
+		v := getPath(o, f.path)
+		if v == nil && f.typeOf {
+			return f.op == diff || f.op == notIn, true
+		}
+		if v == nil {
+			return false, false
+		}
+		if f.typeOf {
+			return f.check(v.Type().String()), true
+		}
+		return v.check(f), true
+	}
+}
+
+// checkFilters returns false if o doesn't match every filters.
+func checkFilters(o *Object, filters []*Filter) bool {
+	for _, filter := range filters {
+		if ok, known := filter.eval(o); known && !ok {
+			return false
+		}
+	}
+	return true
+}
+
 func typed(v string) interface{} {
 	switch v {
 	case "true":
@@ -388,83 +632,362 @@ func typed(v string) interface{} {
 	return v
 }
 
-func newFilter(cmd string) ([]*Filter, error) {
-	filters := make([]*Filter, 0, len(strings.Split(cmd, "&&")))
-	if strings.ContainsAny(cmd, "|") {
-		return nil, fmt.Errorf("Format error in filters : %q", cmd)
+// literal returns the value written text in a query. Quoted values are
+// always strings, the others are typed.
+func literal(text string, quoted bool) interface{} {
+	if quoted {
+		return text
 	}
-	for _, match := range filterRegex.FindAllStringSubmatch(cmd, -1) {
+	return typed(text)
+}
 
-		if len(match[1]) > 0 && len(match[2]) > 0 && len(match[3]) > 0 {
-			op, err := findOperation(match[2])
-			if err != nil {
-				return nil, err
-			}
-			filters = append(filters, &Filter{
-				match[1],
-				op,
-				typed(match[3]),
-			})
-		} else {
-			return nil, fmt.Errorf("Format error in filters : %q", match[0])
+// formatValue returns v as written in a query. Strings are quoted, so that
+// they aren't confused with other values.
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return string(appendQuotedString(nil, v))
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// getPath returns the value for the given keys path in o.
+//
+// nil is returned for non-existing keys path.
+func getPath(o *Object, path []string) *Value {
+	return o.Get(path[0]).Get(path[1:]...)
+}
+
+// field is a value retrieved by a Query.
+type field struct {
+	// name is the key of the value in the output.
+	name string
+
+	// path is the keys path of the value in the input object.
+	path []string
+
+	// deep is set when the values are searched at any depth of the input
+	// object. path contains a single key then.
+	deep bool
+
+	// sel selects elements of the value when it is an array, if not nil.
+	sel *selector
+
+	// query is the nested query applied to the value, nil when the value
+	// is retrieved as is. Its path is a single key.
+	query *Query
+}
+
+// String returns string representation of f.
+func (f field) String() string {
+	keys := make([]string, len(f.path))
+	for i, key := range f.path {
+		keys[i] = quoteKey(key)
+	}
+	p := strings.Join(keys, ".")
+	if f.deep {
+		p = ".." + p
+	}
+	if f.sel != nil {
+		p += f.sel.String()
+	}
+	if f.name != strings.Join(f.path, ".") {
+		return quoteKey(f.name) + ": " + p
+	}
+	return p
+}
+
+// keys returns the keys path of f in error messages, its selector
+// included.
+func (f field) keys() []string {
+	if f.sel == nil {
+		return f.path
+	}
+	keys := append([]string(nil), f.path...)
+	keys[len(keys)-1] += f.sel.String()
+	return keys
+}
+
+// selector selects elements of an array: a single element, such as [0] or
+// [-1], or a slice, such as [2:10]. Negative indexes count from the end of
+// the array.
+type selector struct {
+	// low is the index of the element, or the start of the slice.
+	low int
+
+	// high is the end of the slice, excluded.
+	high int
+
+	slice bool
+
+	// hasLow and hasHigh are false for the omitted bounds of a slice, such
+	// as in [:5] or [2:].
+	hasLow, hasHigh bool
+}
+
+// String returns string representation of s.
+func (s selector) String() string {
+	var b []byte
+	b = append(b, '[')
+	if s.hasLow {
+		b = strconv.AppendInt(b, int64(s.low), 10)
+	}
+	if s.slice {
+		b = append(b, ':')
+		if s.hasHigh {
+			b = strconv.AppendInt(b, int64(s.high), 10)
+		}
+	}
+	return string(append(b, ']'))
+}
+
+// apply returns the element of the array v selected by s, or a new array
+// holding the slice of v selected by s, and the index in v of the element
+// or of the start of the slice.
+//
+// nil is returned if v isn't an array or the index is out of range.
+func (s selector) apply(v *Value) (*Value, int) {
+	if v == nil || v.Type() != TypeArray {
+		return nil, 0
+	}
+	n := len(v.a)
+	if !s.slice {
+		i := s.low
+		if i < 0 {
+			i += n
 		}
+		if i < 0 || i >= n {
+			return nil, 0
+		}
+		return v.a[i], i
+	}
+	low, high := 0, n
+	if s.hasLow {
+		low = sliceBound(s.low, n)
+	}
+	if s.hasHigh {
+		high = sliceBound(s.high, n)
 	}
-	if len(filters) == 0 {
-		return nil, fmt.Errorf("Format error in filters : %q", cmd)
+	if high < low {
+		high = low
 	}
-	return filters, nil
+	return &Value{t: TypeArray, a: v.a[low:high:high]}, low
+}
+
+// sliceBound returns the bound i of a slice of an array of length n, within
+// [0, n].
+func sliceBound(i, n int) int {
+	if i < 0 {
+		i += n
+	}
+	if i < 0 {
+		return 0
+	}
+	if i > n {
+		return n
+	}
+	return i
+}
+
+// quoteKey returns key as written in a query, quoted unless it reads as a
+// single key without quotes.
+func quoteKey(key string) string {
+	if len(key) > 0 && key != "*" && key[0] != '-' && strings.IndexAny(key, punctChars+operationChars+wsChars+`&|".\\`) < 0 {
+		return key
+	}
+	return string(appendQuotedString(nil, key))
+}
+
+// MissingPolicy tells how Keep, Retrieve and Search handle the keys
+// missing from the JSON.
+type MissingPolicy int
+
+const (
+	// MissingOmit skips missing keys. It is the default policy of Keep
+	// and Retrieve.
+	MissingOmit MissingPolicy = iota
+
+	// MissingNull outputs null for missing keys.
+	MissingNull
+
+	// MissingStrict fails with a *MissingKeysError listing the missing
+	// keys. It is the default policy of Search.
+	MissingStrict
+)
+
+// String returns string representation of p, as written in the @missing
+// directive.
+func (p MissingPolicy) String() string {
+	switch p {
+	case MissingOmit:
+		return "omit"
+	case MissingNull:
+		return "null"
+	case MissingStrict:
+		return "strict"
+	default:
+		return fmt.Sprintf("MissingPolicy(%d)", int(p))
+	}
+}
+
+// MissingKeysError is returned under MissingStrict when keys are missing
+// from the JSON.
+type MissingKeysError struct {
+	// Paths are the dot-separated keys paths missing, array indexes
+	// included.
+	Paths []string
+}
+
+// Error implements the error interface.
+func (e *MissingKeysError) Error() string {
+	return "missing keys: " + strings.Join(e.Paths, ", ")
 }
 
 // Query is a description of a Query in a graphql like request
+//
+// fields holds the retrieved values and the nested queries, in the order
+// of the query, which is the order of the output.
+//
+// When all is set, every key of the object which isn't in exclude is
+// kept, in addition to fields. These keys are output before fields[allAt],
+// where the "*" was written.
+//
+// The elements of the arrays the query applies to are sorted by order,
+// then the first offset ones are skipped and at most limit ones are kept
+// when limited is set. These apply after filters, in Keep and Retrieve
+// only: Check ignores them.
+//
+// missing is only read from the root query and applies to the nested ones.
 type Query struct {
 	filters      []*Filter
-	next         map[string]*Query
-	retrieve     []string
+	fields       []field
 	stillFilters bool
+	all          bool
+	allAt        int
+	exclude      []string
+	order        []orderKey
+	limit        int
+	limited      bool
+	offset       int
+	missing      MissingPolicy
+}
+
+// orderKey is a sort key of the order_by argument, such as `id desc`.
+type orderKey struct {
+	// key is the keys path as written in the query.
+	key  string
+	path []string
+	desc bool
+}
+
+// String returns string representation of k.
+func (k orderKey) String() string {
+	if k.desc {
+		return k.key + " desc"
+	}
+	return k.key
+}
+
+// arranges returns true if request sorts or pages the arrays.
+func (request Query) arranges() bool {
+	return len(request.order) > 0 || request.limited || request.offset > 0
+}
+
+// SetMissing sets the policy applied by Keep and Retrieve to the keys of
+// the query missing from the JSON.
+//
+// It may also be set in the query with the @missing directive, such as
+// `@missing(null) {id, name}`.
+func (q *Query) SetMissing(policy MissingPolicy) {
+	q.missing = policy
 }
 
 func (q Query) eq(other Query) bool {
+	if len(q.filters) != len(other.filters) {
+		return false
+	}
 	for index, filter := range q.filters {
 		if other.filters[index] == nil || !filter.eq(*other.filters[index]) {
 			return false
 		}
 	}
-	for key, query := range q.next {
-		if other.next[key] == nil || !query.eq(*other.next[key]) {
+	if len(q.fields) != len(other.fields) {
+		return false
+	}
+	for index, f := range q.fields {
+		o := other.fields[index]
+		if f.String() != o.String() || (f.query == nil) != (o.query == nil) {
+			return false
+		}
+		if f.query != nil && !f.query.eq(*o.query) {
 			return false
 		}
 	}
-	for index, retrieve := range q.retrieve {
-		if retrieve != other.retrieve[index] {
+	if len(q.exclude) != len(other.exclude) {
+		return false
+	}
+	for index, exclude := range q.exclude {
+		if exclude != other.exclude[index] {
 			return false
 		}
 	}
-	return true
+	if len(q.order) != len(other.order) {
+		return false
+	}
+	for index, key := range q.order {
+		if key.String() != other.order[index].String() {
+			return false
+		}
+	}
+	return q.all == other.all && q.allAt == other.allAt && q.missing == other.missing &&
+		q.limit == other.limit && q.limited == other.limited && q.offset == other.offset
 }
 
 func newQuery() Query {
 	return Query{
-		[]*Filter{},
-		map[string]*Query{},
-		[]string{},
-		false,
+		filters: []*Filter{},
+		fields:  []field{},
 	}
 }
 
 func (l Query) print(Query int) {
 	fmt.Printf("%s Filters :\n", strings.Repeat("\t", Query))
 	for _, filter := range l.filters {
-		fmt.Printf("%s - %s %s %q \n", strings.Repeat("\t", Query), (*filter).key, filter.op, filter.val)
+		fmt.Printf("%s - %s\n", strings.Repeat("\t", Query), filter)
 	}
-	fmt.Printf("%s Retrieve :\n", strings.Repeat("\t", Query))
-	for _, retrieve := range l.retrieve {
-		if len(retrieve) > 0 {
-			fmt.Printf("%s - %s\n", strings.Repeat("\t", Query), retrieve)
+	if len(l.order) > 0 {
+		fmt.Printf("%s Order :\n", strings.Repeat("\t", Query))
+		for _, key := range l.order {
+			fmt.Printf("%s - %s\n", strings.Repeat("\t", Query), key)
 		}
 	}
-	fmt.Printf("%s Next :\n", strings.Repeat("\t", Query))
-	for _, next := range l.next {
-		next.print(Query + 1)
+	if l.offset > 0 {
+		fmt.Printf("%s Offset : %d\n", strings.Repeat("\t", Query), l.offset)
+	}
+	if l.limited {
+		fmt.Printf("%s Limit : %d\n", strings.Repeat("\t", Query), l.limit)
+	}
+	fmt.Printf("%s Fields :\n", strings.Repeat("\t", Query))
+	for _, exclude := range l.exclude {
+		fmt.Printf("%s - -%s\n", strings.Repeat("\t", Query), exclude)
+	}
+	for index := 0; index <= len(l.fields); index++ {
+		if l.all && index == l.allAt {
+			fmt.Printf("%s - *\n", strings.Repeat("\t", Query))
+		}
+		if index == len(l.fields) {
+			break
+		}
+		f := l.fields[index]
+		if f.query == nil {
+			fmt.Printf("%s - %s\n", strings.Repeat("\t", Query), f)
+			continue
+		}
+		fmt.Printf("%s - %s :\n", strings.Repeat("\t", Query), f)
+		f.query.print(Query + 1)
 	}
 }
 
@@ -473,83 +996,763 @@ func (l Query) Print() {
 	l.print(0)
 }
 
-func parseQuery(cmd string) (Query *Query, QueryName string, err error) {
-	matches := cmdRegex.FindStringSubmatch(cmd)
+// ParseQuery create a easy traversable structure from a graphql like query.
+//
+// A *SyntaxError locating the problem is returned if cmd is malformed.
+func ParseQuery(cmd string) (parser *Query, err error) {
+	return parseQuery(cmd)
+}
+
+// MustParseQuery is parseQuery without error return. You should be sure of your query syntax !
+func MustParseQuery(cmd string) (parser *Query) {
+	parser, err := parseQuery(cmd)
+	if err != nil {
+		panic(err)
+	}
+	return parser
+}
+
+// queryParser is a recursive descent parser for queries. The grammar of
+// a query is:
+//
+//	query  = { directive } [ key ] body
+//	directive = "@missing" "(" ( "omit" | "null" | "strict" ) ")"
+//	body   = [ "(" [ arg { "," arg } ] ")" ] [ "{" [ field { "," field } ] "}" ]
+//	arg    = expr | "order_by" ":" ( order | "[" order { "," order } "]" )
+//	       | "limit" ":" int | "offset" ":" int
+//	order  = path [ "asc" | "desc" ]
+//	field  = "*" | "-" key | [ alias ":" ] ( path | ".." key ) [ selector ] body
+//	path   = key { "." key }
+//	key    = word | string
+//	selector = "[" ( index | [ index ] ":" [ index ] ) "]"
+//	expr   = and { "||" and }
+//	and    = unary { "&&" unary }
+//	unary  = "!" unary | "(" expr ")" | "has" "(" path ")"
+//	       | operand op value
+//	       | operand [ "not" ] "in" ( list | range )
+//	       | operand [ "not" ] "between" range
+//	operand = path | "type" "(" path ")"
+//	list   = "[" [ value { "," value } ] "]"
+//	range  = ( "[" | "(" ) value ".." value ( "]" | ")" )
+//
+// A field with neither filters nor braces is retrieved as is, the others
+// are nested queries, whose path is a single key. Fields are output under
+// their alias, or under their keys joined with dots when there is none, in the
+// order of the query. Two fields cannot have the same output name.
+//
+// A word key is a run of characters without spaces, dots, punctuation or
+// operation characters, such as `givenName`, `items2` or `205705993`. Other
+// keys are written as JSON strings, such as `"first name"` or `"a.b"`.
+//
+// A selector applies the field to some elements of an array: [i] selects
+// the element at index i and [i:j] the elements from index i to index j
+// excluded, like Go slices. Negative indexes count from the end of the
+// array, so [-1] is the last element. Slice bounds are clamped to the array.
+//
+// "*" keeps every key of the object but the ones excluded with "-" and the
+// ones output by other fields. A field whose path is a single key also
+// takes that key from "*", so that `{*, b: a}` renames a to b. "*" may
+// appear once per body.
+//
+// "..key" searches key at any depth of the object and outputs the array of
+// the values found, under key when there is no alias.
+//
+// A value is either a JSON string, such as `"AT&T (US)"` or `"42"`, or a
+// word typed as a number, true, false or null when it reads as one and as a
+// string otherwise. Since commas separate arguments, a word ends at a comma:
+// `(a = x,y)` is an error and must be written `(a = "x,y")`.
+//
+// has(path) tests whether path exists, and type(path) is the name of the
+// type of its value, such as "array" (see Type.String). type(path) may only
+// be compared with =, != or a list of names with in and not in.
+//
+// The filter expressions of a body are combined with &&. The order_by,
+// limit and offset arguments sort and page the arrays the body applies to,
+// after filtering: `users(id > 1, order_by: [score desc, name], limit: 20)`.
+// Missing values sort last. A filter on a key named like an argument needs
+// the key to be quoted, such as `("limit" : 1)`.
+//
+// The @missing directive sets the missing keys policy of the query, see
+// Query.SetMissing.
+type queryParser struct {
+	query string
+	lx    lexer
+}
+
+func parseQuery(cmd string) (*Query, error) {
+	qp := queryParser{query: cmd, lx: lexer{s: cmd}}
+	missing := MissingOmit
+	for {
+		t := qp.lx.peek()
+		if t.kind != tokenWord || t.text[0] != '@' {
+			break
+		}
+		qp.lx.next()
+		if t.text != "@missing" {
+			return nil, qp.errorf(t, "unknown directive")
+		}
+		var err error
+		if missing, err = qp.parseMissing(); err != nil {
+			return nil, err
+		}
+	}
+	if t := qp.lx.peek(); t.kind == tokenWord || t.kind == tokenString {
+		qp.lx.next()
+		if _, err := qp.key(t); err != nil {
+			return nil, err
+		}
+	}
+	lvl, err := qp.parseBody()
+	if err != nil {
+		return nil, err
+	}
+	lvl.missing = missing
+	if t := qp.lx.next(); t.kind != tokenEOF {
+		return nil, qp.errorf(t, "unexpected %s", t.kind)
+	}
+	return lvl, nil
+}
+
+// parseMissing parses the argument of the @missing directive.
+func (qp *queryParser) parseMissing() (MissingPolicy, error) {
+	if err := qp.expect("("); err != nil {
+		return 0, err
+	}
+	t := qp.lx.next()
+	var missing MissingPolicy
+	switch {
+	case t.is(tokenWord, "omit"):
+		missing = MissingOmit
+	case t.is(tokenWord, "null"):
+		missing = MissingNull
+	case t.is(tokenWord, "strict"):
+		missing = MissingStrict
+	default:
+		return 0, qp.errorf(t, "expecting omit, null or strict")
+	}
+	return missing, qp.expect(")")
+}
+
+func (qp *queryParser) errorf(t token, format string, args ...interface{}) error {
+	return newSyntaxError(qp.query, t, format, args...)
+}
+
+func (qp *queryParser) expect(punct string) error {
+	if t := qp.lx.next(); !t.is(tokenPunct, punct) {
+		return qp.errorf(t, "expecting '%s'", punct)
+	}
+	return nil
+}
+
+func (qp *queryParser) parseBody() (*Query, error) {
 	lvl := newQuery()
-	if len(matches) == 0 {
-		return nil, "", fmt.Errorf("mal formated")
+	if qp.lx.peek().is(tokenPunct, "(") {
+		qp.lx.next()
+		if err := qp.parseArguments(&lvl); err != nil {
+			return nil, err
+		}
 	}
-	if len(matches) > 2 && len(matches[2]) > 0 {
-		filters, err := newFilter(matches[2])
-		if err != nil {
-			return nil, "", err
+	if !qp.lx.peek().is(tokenPunct, "{") {
+		return &lvl, nil
+	}
+	qp.lx.next()
+	if qp.lx.peek().is(tokenPunct, "}") {
+		qp.lx.next()
+		return &lvl, nil
+	}
+	// exclusion is the first excluded key, if any.
+	var exclusion token
+	for {
+		t := qp.lx.next()
+		if t.kind != tokenWord && t.kind != tokenString {
+			return nil, qp.errorf(t, "expecting key")
+		}
+		switch {
+		case t.is(tokenWord, "*"):
+			if lvl.all {
+				return nil, qp.errorf(t, "duplicate '*'")
+			}
+			lvl.all = true
+			lvl.allAt = len(lvl.fields)
+		case t.kind == tokenWord && t.text[0] == '-':
+			if len(lvl.exclude) == 0 {
+				exclusion = t
+			}
+			qp.lx.rewind(t.offset + 1)
+			k := qp.lx.nextKey()
+			if len(k.text) == 0 {
+				return nil, qp.errorf(t, "missing excluded key")
+			}
+			key, err := qp.key(k)
+			if err != nil {
+				return nil, err
+			}
+			lvl.exclude = append(lvl.exclude, key)
+		default:
+			if err := qp.parseField(&lvl, t); err != nil {
+				return nil, err
+			}
 		}
-		for _, filter := range filters {
-			if filter != nil {
+
+		t = qp.lx.next()
+		if t.is(tokenPunct, "}") {
+			if len(lvl.exclude) > 0 && !lvl.all {
+				return nil, qp.errorf(exclusion, "exclusion without '*'")
+			}
+			return &lvl, nil
+		}
+		if !t.is(tokenPunct, ",") {
+			return nil, qp.errorf(t, "expecting ',' or '}'")
+		}
+	}
+}
+
+// parseArguments parses the arguments of a body following its '(' and
+// sets them in lvl.
+func (qp *queryParser) parseArguments(lvl *Query) error {
+	if qp.lx.peek().is(tokenPunct, ")") {
+		qp.lx.next()
+		return nil
+	}
+	seen := map[string]bool{}
+	for {
+		t := qp.lx.peek()
+		argument := t.is(tokenWord, "order_by") || t.is(tokenWord, "limit") || t.is(tokenWord, "offset")
+		if argument {
+			qp.lx.next()
+			if argument = qp.lx.peek().is(tokenOperation, ":"); !argument {
+				qp.lx.rewind(t.offset)
+			}
+		}
+		if argument {
+			qp.lx.next()
+			if seen[t.text] {
+				return qp.errorf(t, "duplicate argument %s", t.text)
+			}
+			seen[t.text] = true
+			if err := qp.parseArgument(lvl, t); err != nil {
+				return err
+			}
+		} else {
+			filter, err := qp.parseOr()
+			if err != nil {
+				return err
+			}
+			if filter.op == and {
+				lvl.filters = append(lvl.filters, filter.sub...)
+			} else {
 				lvl.filters = append(lvl.filters, filter)
 			}
+			lvl.stillFilters = true
+		}
+		t = qp.lx.next()
+		if t.is(tokenPunct, ")") {
+			return nil
+		}
+		if !t.is(tokenPunct, ",") {
+			return qp.errorf(t, "expecting ',' or ')'")
+		}
+	}
+}
+
+// parseArgument parses the value of the argument name, following its ':'.
+func (qp *queryParser) parseArgument(lvl *Query, name token) error {
+	if name.text == "order_by" {
+		if !qp.lx.peek().is(tokenPunct, "[") {
+			key, err := qp.parseOrderKey()
+			if err != nil {
+				return err
+			}
+			lvl.order = append(lvl.order, key)
+			return nil
+		}
+		qp.lx.next()
+		for {
+			key, err := qp.parseOrderKey()
+			if err != nil {
+				return err
+			}
+			lvl.order = append(lvl.order, key)
+			t := qp.lx.next()
+			if t.is(tokenPunct, "]") {
+				return nil
+			}
+			if !t.is(tokenPunct, ",") {
+				return qp.errorf(t, "expecting ',' or ']'")
+			}
 		}
-		if len(lvl.filters) > 0 {
+	}
+	t := qp.lx.next()
+	n, err := strconv.Atoi(t.text)
+	if t.kind != tokenWord || err != nil || n < 0 {
+		return qp.errorf(t, "invalid %s", name.text)
+	}
+	if name.text == "limit" {
+		lvl.limit, lvl.limited = n, true
+	} else {
+		lvl.offset = n
+	}
+	return nil
+}
+
+// parseOrderKey parses a sort key of the order_by argument.
+func (qp *queryParser) parseOrderKey() (orderKey, error) {
+	t := qp.lx.next()
+	if t.kind != tokenWord && t.kind != tokenString {
+		return orderKey{}, qp.errorf(t, "expecting key")
+	}
+	key, path, err := qp.filterPath(t)
+	if err != nil {
+		return orderKey{}, err
+	}
+	k := orderKey{key: key.text, path: path}
+	if t := qp.lx.peek(); t.is(tokenWord, "asc") || t.is(tokenWord, "desc") {
+		qp.lx.next()
+		k.desc = t.text == "desc"
+	}
+	return k, nil
+}
+
+// parseField parses the field starting with t and adds it to lvl.
+func (qp *queryParser) parseField(lvl *Query, t token) error {
+	nameToken, name := t, ""
+	alias := qp.lx.peek().is(tokenOperation, ":")
+	if alias {
+		var err error
+		if name, err = qp.key(t); err != nil {
+			return err
+		}
+		qp.lx.next()
+		if t = qp.lx.next(); t.kind != tokenWord && t.kind != tokenString {
+			return qp.errorf(t, "expecting key")
+		}
+	}
+	offset, deep := t.offset, t.kind == tokenWord && strings.HasPrefix(t.text, "..")
+	if deep {
+		offset += 2
+	}
+	path, err := qp.parseKeyPath(offset, t)
+	if err != nil {
+		return err
+	}
+	if deep && len(path) != 1 {
+		return qp.errorf(t, "invalid recursive key")
+	}
+	if !alias {
+		name = strings.Join(path, ".")
+	}
+	var sel *selector
+	if open := qp.lx.peek(); open.is(tokenPunct, "[") {
+		qp.lx.next()
+		if sel, err = qp.parseSelector(); err != nil {
+			return err
+		}
+	}
+	for _, f := range lvl.fields {
+		if f.name == name {
+			return qp.errorf(nameToken, "duplicate field %s", name)
+		}
+	}
+	if next := qp.lx.peek(); next.is(tokenPunct, "(") || next.is(tokenPunct, "{") {
+		if len(path) != 1 {
+			return qp.errorf(t, "invalid block name")
+		}
+		child, err := qp.parseBody()
+		if err != nil {
+			return err
+		}
+		if child.stillFilters {
 			lvl.stillFilters = true
 		}
+		lvl.fields = append(lvl.fields, field{name: name, path: path, deep: deep, sel: sel, query: child})
+		return nil
 	}
-	if len(matches) > 3 && len(matches[3]) > 0 {
-		for _, attr := range splitComa(matches[3]) {
-			if strings.ContainsAny(attr, "(){}") {
-				newQuery, QueryName, _ := parseQuery(attr)
-				if newQuery.stillFilters == true {
-					lvl.stillFilters = true
-				}
-				lvl.next[QueryName] = newQuery
-			} else {
-				lvl.retrieve = append(lvl.retrieve, attr)
+	lvl.fields = append(lvl.fields, field{name: name, path: path, deep: deep, sel: sel})
+	return nil
+}
+
+// parseSelector parses the array selector following its '['.
+func (qp *queryParser) parseSelector() (*selector, error) {
+	sel := &selector{}
+	index := func(t token) (int, error) {
+		i, err := strconv.Atoi(t.text)
+		if err != nil {
+			return 0, qp.errorf(t, "invalid index")
+		}
+		return i, nil
+	}
+	t := qp.lx.next()
+	if t.kind == tokenWord {
+		i, err := index(t)
+		if err != nil {
+			return nil, err
+		}
+		sel.low, sel.hasLow = i, true
+		t = qp.lx.next()
+	}
+	if t.is(tokenOperation, ":") {
+		sel.slice = true
+		if t = qp.lx.next(); t.kind == tokenWord {
+			i, err := index(t)
+			if err != nil {
+				return nil, err
 			}
+			sel.high, sel.hasHigh = i, true
+			t = qp.lx.next()
 		}
+	} else if !sel.hasLow {
+		return nil, qp.errorf(t, "expecting index")
 	}
-	return &lvl, matches[1], nil
+	if !t.is(tokenPunct, "]") {
+		return nil, qp.errorf(t, "expecting ']'")
+	}
+	return sel, nil
 }
 
-// ParseQuery create a easy traversable structure from a graphql like query.
-func ParseQuery(cmd string) (parser *Query, err error) {
-	parser, _, err = parseQuery(cmd)
-	return parser, err
+// parseKeyPath parses the keys path starting at offset, within the token t
+// which was already read. t locates the errors.
+func (qp *queryParser) parseKeyPath(offset int, t token) ([]string, error) {
+	qp.lx.rewind(offset)
+	var path []string
+	for {
+		k := qp.lx.nextKey()
+		if len(k.text) == 0 {
+			return nil, qp.errorf(t, "invalid key path")
+		}
+		key, err := qp.key(k)
+		if err != nil {
+			return nil, err
+		}
+		path = append(path, key)
+		if !qp.lx.skipDot() {
+			return path, nil
+		}
+	}
 }
 
-// MustParseQuery is parseQuery without error return. You should be sure of your query syntax !
-func MustParseQuery(cmd string) (parser *Query) {
-	parser, _, err := parseQuery(cmd)
+// key returns the key written as t, unquoting strings.
+func (qp *queryParser) key(t token) (string, error) {
+	switch t.kind {
+	case tokenWord:
+		return t.text, nil
+	case tokenString:
+		key, err := unquoteString(t.text[1:len(t.text)-1], '"')
+		if err != nil {
+			return "", qp.errorf(t, "invalid key: %s", err)
+		}
+		return key, nil
+	default:
+		return "", qp.errorf(t, "expecting key")
+	}
+}
+
+func (qp *queryParser) parseOr() (*Filter, error) {
+	return qp.parseGroup(or, tokenOr, qp.parseAnd)
+}
+
+func (qp *queryParser) parseAnd() (*Filter, error) {
+	return qp.parseGroup(and, tokenAnd, qp.parseUnary)
+}
+
+// parseGroup parses operands separated by the kind token. The operand
+// itself is returned when there is only one.
+func (qp *queryParser) parseGroup(op Operation, kind tokenKind, operand func() (*Filter, error)) (*Filter, error) {
+	first, err := operand()
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	return parser
+	group := &Filter{op: op, sub: []*Filter{first}}
+	for qp.lx.peek().kind == kind {
+		qp.lx.next()
+		next, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		group.sub = append(group.sub, next)
+	}
+	if len(group.sub) == 1 {
+		return first, nil
+	}
+	return group, nil
 }
 
-func splitComa(line string) []string {
-	array := []string{}
-	runes := []rune(string(line))
-	count := 0
-	firstIndex := 0
-	for index, char := range line {
-		switch char {
-		case '{':
-			count++
-		case '}':
-			count--
-		case ' ', '\n', '\t':
-			if count == 0 {
-				firstIndex++
+func (qp *queryParser) parseUnary() (*Filter, error) {
+	t := qp.lx.next()
+	switch {
+	case t.is(tokenOperation, "!"):
+		sub, err := qp.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &Filter{op: not, sub: []*Filter{sub}}, nil
+	case t.is(tokenPunct, "("):
+		sub, err := qp.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := qp.expect(")"); err != nil {
+			return nil, err
+		}
+		return sub, nil
+	case t.kind == tokenWord || t.kind == tokenString:
+		if t.kind == tokenWord && (t.text == "has" || t.text == "type") && qp.lx.peek().is(tokenPunct, "(") {
+			return qp.parsePredicate(t)
+		}
+		return qp.parseComparison(t)
+	default:
+		return nil, qp.errorf(t, "expecting filter")
+	}
+}
+
+// parsePredicate parses the has or type predicate started by name, such as
+// `has(tags)` or `type(tags) = array`.
+func (qp *queryParser) parsePredicate(name token) (*Filter, error) {
+	qp.lx.next()
+	key := qp.lx.next()
+	if key.kind != tokenWord && key.kind != tokenString {
+		return nil, qp.errorf(key, "expecting key")
+	}
+	key, path, err := qp.filterPath(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := qp.expect(")"); err != nil {
+		return nil, err
+	}
+	if name.text == "has" {
+		return &Filter{key: key.text, path: path, op: has}, nil
+	}
+	op := qp.lx.peek()
+	if op.kind == tokenOperation && op.text != "=" && op.text != "!=" {
+		return nil, qp.errorf(op, "expecting =, !=, in or not in after type()")
+	}
+	f, err := qp.parseOperation(key.text, path, true)
+	if err != nil {
+		return nil, err
+	}
+	if f.op == between || f.op == notBetween {
+		return nil, qp.errorf(op, "type names cannot be compared with a range")
+	}
+	f.typeOf = true
+	return f, nil
+}
+
+// typeName returns the type name v, the value of t, stands for in a type
+// predicate. The values true, false and null are typed like in other
+// filters, so they are turned back into names.
+func (qp *queryParser) typeName(t token, v interface{}) (interface{}, error) {
+	var name string
+	switch v := v.(type) {
+	case nil:
+		name = "null"
+	case bool:
+		name = strconv.FormatBool(v)
+	case string:
+		name = v
+	}
+	switch name {
+	case "object", "array", "string", "number", "true", "false", "null":
+		return name, nil
+	default:
+		return "", qp.errorf(t, "unknown type %v", v)
+	}
+}
+
+// filterPath returns the filter key starting with t, as written in the
+// query, and its keys path.
+func (qp *queryParser) filterPath(t token) (token, []string, error) {
+	path, err := qp.parseKeyPath(t.offset, t)
+	if err != nil {
+		return t, nil, err
+	}
+	t.text = qp.query[t.offset:qp.lx.pos]
+	return t, path, nil
+}
+
+func (qp *queryParser) parseComparison(t token) (*Filter, error) {
+	key, path, err := qp.filterPath(t)
+	if err != nil {
+		return nil, err
+	}
+	return qp.parseOperation(key.text, path, false)
+}
+
+// parseOperation parses the operation and the value compared with the
+// value of key. The values are type names if typeOf is set.
+func (qp *queryParser) parseOperation(key string, path []string, typeOf bool) (*Filter, error) {
+	t := qp.lx.next()
+	switch {
+	case t.is(tokenWord, "in"), t.is(tokenWord, "between"):
+		return qp.parseList(key, path, t.text == "in", false, typeOf)
+	case t.is(tokenWord, "not"):
+		t = qp.lx.next()
+		if !t.is(tokenWord, "in") && !t.is(tokenWord, "between") {
+			return nil, qp.errorf(t, "expecting 'in' or 'between'")
+		}
+		return qp.parseList(key, path, t.text == "in", true, typeOf)
+	case t.kind == tokenInvalid && strings.IndexByte(operationChars, t.text[0]) >= 0:
+		return nil, qp.errorf(t, "unexpected character in operation")
+	case t.kind != tokenOperation:
+		return nil, qp.errorf(t, "expecting operation")
+	}
+	op, err := findOperation(t.text)
+	if err != nil {
+		return nil, qp.errorf(t, "%s", err)
+	}
+	t = qp.lx.nextValue()
+	text, err := qp.valueText(t)
+	if err != nil {
+		return nil, err
+	}
+	f, err := newFilter(key, path, op, text, t.kind == tokenString)
+	if err != nil {
+		return nil, qp.errorf(t, "invalid pattern: %s", err)
+	}
+	if typeOf {
+		if f.val, err = qp.typeName(t, f.val); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// parseList parses the list of values of an in operation, such as
+// `["open", "pending"]`, or the range of a between operation, such as
+// `[10..50)`. Ranges are also accepted by in when list is set. The items
+// of lists are type names if typeOf is set.
+func (qp *queryParser) parseList(key string, path []string, list, negate, typeOf bool) (*Filter, error) {
+	open := qp.lx.next()
+	if !open.is(tokenPunct, "[") && !open.is(tokenPunct, "(") {
+		if list {
+			return nil, qp.errorf(open, "expecting '[' or '('")
+		}
+		return nil, qp.errorf(open, "expecting range")
+	}
+	f := &Filter{key: key, path: path, op: in}
+	if negate {
+		f.op = notIn
+	}
+	items := []interface{}{}
+	if open.text == "[" && qp.lx.peek().is(tokenPunct, "]") {
+		qp.lx.next()
+		if !list {
+			return nil, qp.errorf(open, "expecting range")
+		}
+		f.val = items
+		return f, nil
+	}
+	for {
+		t := qp.lx.nextListValue()
+		text, err := qp.valueText(t)
+		if err != nil {
+			return nil, err
+		}
+		if len(items) == 0 {
+			r, ok, err := qp.parseRange(open, t, text)
+			if err != nil {
+				return nil, err
 			}
-		case ',':
-			if count == 0 {
-				array = append(array, string(runes[firstIndex:index]))
-				firstIndex = index + 1
+			if ok {
+				f.val = r
+				f.op = between
+				if negate {
+					f.op = notBetween
+				}
+				return f, nil
 			}
-		default:
-			continue
+			if open.text == "(" || !list {
+				return nil, qp.errorf(open, "expecting range")
+			}
+		}
+		item := literal(text, t.kind == tokenString)
+		if typeOf {
+			if item, err = qp.typeName(t, item); err != nil {
+				return nil, err
+			}
+		}
+		items = append(items, item)
+		t = qp.lx.next()
+		if t.is(tokenPunct, "]") {
+			f.val = items
+			return f, nil
+		}
+		if !t.is(tokenPunct, ",") {
+			return nil, qp.errorf(t, "expecting ',' or ']'")
+		}
+	}
+}
+
+// parseRange parses the rest of the range opened by open, whose lower
+// bound is the value t of the given text. ok is false if t doesn't start a
+// range.
+func (qp *queryParser) parseRange(open, t token, text string) (r valueRange, ok bool, err error) {
+	low := literal(text, t.kind == tokenString)
+	var high interface{}
+	hasHigh := false
+	if i := strings.Index(text, ".."); t.kind == tokenWord && i >= 0 {
+		if i == 0 {
+			return r, false, qp.errorf(t, "missing lower bound")
+		}
+		low = typed(text[:i])
+		if len(text) > i+2 {
+			high, hasHigh = typed(text[i+2:]), true
+		}
+	} else if qp.lx.peek().is(tokenWord, "..") {
+		qp.lx.next()
+	} else {
+		return r, false, nil
+	}
+	if !hasHigh {
+		h := qp.lx.nextListValue()
+		text, err := qp.valueText(h)
+		if err != nil {
+			return r, false, err
+		}
+		high = literal(text, h.kind == tokenString)
+	}
+	r = valueRange{low: low, high: high, lowOpen: open.text == "("}
+	if boundKind(r.low) == 0 || boundKind(r.low) != boundKind(r.high) {
+		return r, false, qp.errorf(t, "range bounds must be both numbers or both strings")
+	}
+	end := qp.lx.next()
+	switch {
+	case end.is(tokenPunct, "]"):
+	case end.is(tokenPunct, ")"):
+		r.highOpen = true
+	default:
+		return r, false, qp.errorf(end, "expecting ']' or ')'")
+	}
+	return r, true, nil
+}
+
+// boundKind returns 1 for numbers, 2 for strings and 0 for the values
+// which can't be range bounds.
+func boundKind(v interface{}) int {
+	switch v.(type) {
+	case int64, float64:
+		return 1
+	case string:
+		return 2
+	default:
+		return 0
+	}
+}
+
+// valueText returns the text of the value t. Strings are unquoted and
+// their escapes decoded, like in JSON.
+func (qp *queryParser) valueText(t token) (string, error) {
+	switch t.kind {
+	case tokenInvalid:
+		return "", qp.errorf(t, `missing closing '"'`)
+	case tokenString:
+		text, err := unquoteString(t.text[1:len(t.text)-1], '"')
+		if err != nil {
+			return "", qp.errorf(t, "invalid string: %s", err)
 		}
+		return text, nil
 	}
-	if firstIndex < len(line) {
-		array = append(array, string(runes[firstIndex:len(line)]))
+	if len(t.text) == 0 {
+		return "", qp.errorf(t, "missing value")
 	}
-	return array
+	return t.text, nil
 }
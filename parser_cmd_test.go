@@ -1,9 +1,22 @@
 package jsonq
 
 import (
+	"bytes"
+	"io"
+	"strings"
 	"testing"
 )
 
+// fields returns the fields retrieved under their own name for the given
+// keys paths.
+func fields(paths ...string) []field {
+	fs := make([]field, 0, len(paths))
+	for _, path := range paths {
+		fs = append(fs, field{name: path, path: strings.Split(path, ".")})
+	}
+	return fs
+}
+
 func TestMustParseQuery(t *testing.T) {
 	type args struct {
 		cmd string
@@ -13,23 +26,23 @@ func TestMustParseQuery(t *testing.T) {
 		args       args
 		wantParser *Query
 	}{
-		{"retrieve only", args{"{}"}, &Query{[]*Filter{}, map[string]*Query{}, []string{}, false}},
-		{"retrieve only", args{"{a,b,c}"}, &Query{[]*Filter{}, map[string]*Query{}, []string{"a", "b", "c"}, false}},
-		{"retrieve only", args{"{a, b, c}"}, &Query{[]*Filter{}, map[string]*Query{}, []string{"a", "b", "c"}, false}},
-		{"filter only", args{"(a : 1){}"}, &Query{[]*Filter{&Filter{"a", ":", 1}}, map[string]*Query{}, []string{}, false}},
-		{"filter only", args{"(a:1){}"}, &Query{[]*Filter{&Filter{"a", ":", 1}}, map[string]*Query{}, []string{}, false}},
-		{"filter only", args{"(a :: 1){}"}, &Query{[]*Filter{&Filter{"a", "::", 1}}, map[string]*Query{}, []string{}, false}},
-		{"filter only", args{"(a::1){}"}, &Query{[]*Filter{&Filter{"a", "::", 1}}, map[string]*Query{}, []string{}, false}},
-		{"filter only", args{"(a>1){}"}, &Query{[]*Filter{&Filter{"a", ">", 1}}, map[string]*Query{}, []string{}, false}},
-		{"filter only", args{"(a > 1){}"}, &Query{[]*Filter{&Filter{"a", ">", 1}}, map[string]*Query{}, []string{}, false}},
-		{"filter only", args{"(a<1){}"}, &Query{[]*Filter{&Filter{"a", "<", 1}}, map[string]*Query{}, []string{}, false}},
-		{"filter only", args{"(a < 1){}"}, &Query{[]*Filter{&Filter{"a", "<", 1}}, map[string]*Query{}, []string{}, false}},
-		{"filter only", args{"(a=1){}"}, &Query{[]*Filter{&Filter{"a", "=", 1}}, map[string]*Query{}, []string{}, false}},
-		{"filter only", args{"(a = 1){}"}, &Query{[]*Filter{&Filter{"a", "=", 1}}, map[string]*Query{}, []string{}, false}},
-		{"filter only", args{"(a!=1){}"}, &Query{[]*Filter{&Filter{"a", "!=", 1}}, map[string]*Query{}, []string{}, false}},
-		{"filter only", args{"(a != 1){}"}, &Query{[]*Filter{&Filter{"a", "!=", 1}}, map[string]*Query{}, []string{}, false}},
-		{"filter twice", args{"(a = 1 && b > 0){}"}, &Query{[]*Filter{&Filter{"a", "=", 1}, &Filter{"b", ">", 0}}, map[string]*Query{}, []string{}, false}},
-		{"filter  and retrieve", args{"(a = 1 && b > 0){a,b,c{x,y,z}}"}, &Query{[]*Filter{&Filter{"a", "=", 1}, &Filter{"b", ">", 0}}, map[string]*Query{"c": &Query{[]*Filter{}, map[string]*Query{}, []string{"x", "y", "z"}, false}}, []string{"a", "b"}, false}},
+		{"retrieve only", args{"{}"}, &Query{}},
+		{"retrieve only", args{"{a,b,c}"}, &Query{fields: fields("a", "b", "c")}},
+		{"retrieve only", args{"{a, b, c}"}, &Query{fields: fields("a", "b", "c")}},
+		{"filter only", args{"(a : 1){}"}, &Query{filters: []*Filter{&Filter{key: "a", op: ":", val: 1}}}},
+		{"filter only", args{"(a:1){}"}, &Query{filters: []*Filter{&Filter{key: "a", op: ":", val: 1}}}},
+		{"filter only", args{"(a :: 1){}"}, &Query{filters: []*Filter{&Filter{key: "a", op: "::", val: 1}}}},
+		{"filter only", args{"(a::1){}"}, &Query{filters: []*Filter{&Filter{key: "a", op: "::", val: 1}}}},
+		{"filter only", args{"(a>1){}"}, &Query{filters: []*Filter{&Filter{key: "a", op: ">", val: 1}}}},
+		{"filter only", args{"(a > 1){}"}, &Query{filters: []*Filter{&Filter{key: "a", op: ">", val: 1}}}},
+		{"filter only", args{"(a<1){}"}, &Query{filters: []*Filter{&Filter{key: "a", op: "<", val: 1}}}},
+		{"filter only", args{"(a < 1){}"}, &Query{filters: []*Filter{&Filter{key: "a", op: "<", val: 1}}}},
+		{"filter only", args{"(a=1){}"}, &Query{filters: []*Filter{&Filter{key: "a", op: "=", val: 1}}}},
+		{"filter only", args{"(a = 1){}"}, &Query{filters: []*Filter{&Filter{key: "a", op: "=", val: 1}}}},
+		{"filter only", args{"(a!=1){}"}, &Query{filters: []*Filter{&Filter{key: "a", op: "!=", val: 1}}}},
+		{"filter only", args{"(a != 1){}"}, &Query{filters: []*Filter{&Filter{key: "a", op: "!=", val: 1}}}},
+		{"filter twice", args{"(a = 1 && b > 0){}"}, &Query{filters: []*Filter{&Filter{key: "a", op: "=", val: 1}, &Filter{key: "b", op: ">", val: 0}}}},
+		{"filter  and retrieve", args{"(a = 1 && b > 0){a,b,c{x,y,z}}"}, &Query{filters: []*Filter{&Filter{key: "a", op: "=", val: 1}, &Filter{key: "b", op: ">", val: 0}}, fields: append(fields("a", "b"), field{name: "c", path: []string{"c"}, query: &Query{fields: fields("x", "y", "z")}})}},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -50,29 +63,115 @@ func TestParseQuery(t *testing.T) {
 		wantParser *Query
 		wantErr    bool
 	}{
-		{"retrieve only", args{"{}"}, &Query{[]*Filter{}, map[string]*Query{}, []string{}, false}, false},
-		{"retrieve only", args{"{a,b,c}"}, &Query{[]*Filter{}, map[string]*Query{}, []string{"a", "b", "c"}, false}, false},
-		{"retrieve only", args{"{a, b, c}"}, &Query{[]*Filter{}, map[string]*Query{}, []string{"a", "b", "c"}, false}, false},
-		{"filter only", args{"(a : 1){}"}, &Query{[]*Filter{&Filter{"a", ":", 1}}, map[string]*Query{}, []string{}, false}, false},
-		{"filter only", args{"(a:1){}"}, &Query{[]*Filter{&Filter{"a", ":", 1}}, map[string]*Query{}, []string{}, false}, false},
-		{"filter only", args{"(a :: 1){}"}, &Query{[]*Filter{&Filter{"a", "::", 1}}, map[string]*Query{}, []string{}, false}, false},
-		{"filter only", args{"(a::1){}"}, &Query{[]*Filter{&Filter{"a", "::", 1}}, map[string]*Query{}, []string{}, false}, false},
-		{"filter only", args{"(a>1){}"}, &Query{[]*Filter{&Filter{"a", ">", 1}}, map[string]*Query{}, []string{}, false}, false},
-		{"filter only", args{"(a > 1){}"}, &Query{[]*Filter{&Filter{"a", ">", 1}}, map[string]*Query{}, []string{}, false}, false},
-		{"filter only", args{"(a<1){}"}, &Query{[]*Filter{&Filter{"a", "<", 1}}, map[string]*Query{}, []string{}, false}, false},
-		{"filter only", args{"(a < 1){}"}, &Query{[]*Filter{&Filter{"a", "<", 1}}, map[string]*Query{}, []string{}, false}, false},
-		{"filter only", args{"(a=1){}"}, &Query{[]*Filter{&Filter{"a", "=", 1}}, map[string]*Query{}, []string{}, false}, false},
-		{"filter only", args{"(a = 1){}"}, &Query{[]*Filter{&Filter{"a", "=", 1}}, map[string]*Query{}, []string{}, false}, false},
-		{"filter only", args{"(a!=1){}"}, &Query{[]*Filter{&Filter{"a", "!=", 1}}, map[string]*Query{}, []string{}, false}, false},
-		{"filter only", args{"(a != 1){}"}, &Query{[]*Filter{&Filter{"a", "!=", 1}}, map[string]*Query{}, []string{}, false}, false},
-		{"filter twice", args{"(a = 1 && b > 0){}"}, &Query{[]*Filter{&Filter{"a", "=", 1}, &Filter{"b", ">", 0}}, map[string]*Query{}, []string{}, false}, false},
-		{"filter  and retrieve", args{"(a = 1 && b > 0){a,b,c{x,y,z}}"}, &Query{[]*Filter{&Filter{"a", "=", 1}, &Filter{"b", ">", 0}}, map[string]*Query{"c": &Query{[]*Filter{}, map[string]*Query{}, []string{"x", "y", "z"}, false}}, []string{"a", "b"}, false}, false},
+		{"retrieve only", args{"{}"}, &Query{}, false},
+		{"retrieve only", args{"{a,b,c}"}, &Query{fields: fields("a", "b", "c")}, false},
+		{"retrieve only", args{"{a, b, c}"}, &Query{fields: fields("a", "b", "c")}, false},
+		{"filter only", args{"(a : 1){}"}, &Query{filters: []*Filter{&Filter{key: "a", op: ":", val: 1}}}, false},
+		{"filter only", args{"(a:1){}"}, &Query{filters: []*Filter{&Filter{key: "a", op: ":", val: 1}}}, false},
+		{"filter only", args{"(a :: 1){}"}, &Query{filters: []*Filter{&Filter{key: "a", op: "::", val: 1}}}, false},
+		{"filter only", args{"(a::1){}"}, &Query{filters: []*Filter{&Filter{key: "a", op: "::", val: 1}}}, false},
+		{"filter in", args{`(status in ["open", pending, 3, true, null]){}`}, &Query{filters: []*Filter{&Filter{key: "status", op: "in", val: []interface{}{"open", "pending", 3, true, nil}}}}, false},
+		{"filter in", args{"(id not in [1,2] && id in []){}"}, &Query{filters: []*Filter{&Filter{key: "id", op: "not in", val: []interface{}{1, 2}}, &Filter{key: "id", op: "in", val: []interface{}{}}}}, false},
+		{"filter in", args{"(id in 1){}"}, nil, true},
+		{"filter in", args{"(id in [1,]){}"}, nil, true},
+		{"filter in", args{"(id in [1 2]){}"}, nil, true},
+		{"filter in", args{"(id not [1]){}"}, nil, true},
+		{"filter range", args{"(price in [10..50)){}"}, &Query{filters: []*Filter{&Filter{key: "price", op: "between", val: valueRange{low: 10, high: 50, highOpen: true}}}}, false},
+		{"filter range", args{`(name not between ("a" .. "m"]){}`}, &Query{filters: []*Filter{&Filter{key: "name", op: "not between", val: valueRange{low: "a", high: "m", lowOpen: true}}}}, false},
+		{"filter range", args{"(price between [-1.5..2.5]){}"}, &Query{filters: []*Filter{&Filter{key: "price", op: "between", val: valueRange{low: -1.5, high: 2.5}}}}, false},
+		{"filter range", args{"(price between [1, 2]){}"}, nil, true},
+		{"filter range", args{"(price between []){}"}, nil, true},
+		{"filter range", args{"(price in (1, 2)){}"}, nil, true},
+		{"filter range", args{"(price in [1..a]){}"}, nil, true},
+		{"filter range", args{"(price in [true..false]){}"}, nil, true},
+		{"filter range", args{"(price in [..2]){}"}, nil, true},
+		{"filter range", args{"(price in [1..2}){}"}, nil, true},
+		{"filter has", args{"(has(a.b) && !has(c)){}"}, &Query{filters: []*Filter{&Filter{key: "a.b", op: "has"}, &Filter{op: "!", sub: []*Filter{&Filter{key: "c", op: "has"}}}}}, false},
+		{"filter type", args{`(type(a) = "array" || type(b) in [null, true, object]){}`}, &Query{filters: []*Filter{&Filter{op: "||", sub: []*Filter{&Filter{key: "a", op: "=", val: "array", typeOf: true}, &Filter{key: "b", op: "in", val: []interface{}{"null", "true", "object"}, typeOf: true}}}}}, false},
+		{"filter type", args{"(type(a) = list){}"}, nil, true},
+		{"filter type", args{"(type(a) in [string, 1]){}"}, nil, true},
+		{"filter has", args{"(has(a) = 1){}"}, nil, true},
+		{"filter has", args{"(has()){}"}, nil, true},
+		{"filter has", args{"(has(a){}"}, nil, true},
+		{"filter only", args{"(a := b && c !::= d){}"}, &Query{filters: []*Filter{&Filter{key: "a", op: ":=", val: "b"}, &Filter{key: "c", op: "!::=", val: "d"}}}, false},
+		{"filter only", args{"(a>1){}"}, &Query{filters: []*Filter{&Filter{key: "a", op: ">", val: 1}}}, false},
+		{"filter only", args{"(a > 1){}"}, &Query{filters: []*Filter{&Filter{key: "a", op: ">", val: 1}}}, false},
+		{"filter only", args{"(a<1){}"}, &Query{filters: []*Filter{&Filter{key: "a", op: "<", val: 1}}}, false},
+		{"filter only", args{"(a < 1){}"}, &Query{filters: []*Filter{&Filter{key: "a", op: "<", val: 1}}}, false},
+		{"filter only", args{"(a=1){}"}, &Query{filters: []*Filter{&Filter{key: "a", op: "=", val: 1}}}, false},
+		{"filter only", args{"(a = 1){}"}, &Query{filters: []*Filter{&Filter{key: "a", op: "=", val: 1}}}, false},
+		{"filter only", args{"(a!=1){}"}, &Query{filters: []*Filter{&Filter{key: "a", op: "!=", val: 1}}}, false},
+		{"filter only", args{"(a != 1){}"}, &Query{filters: []*Filter{&Filter{key: "a", op: "!=", val: 1}}}, false},
+		{"filter twice", args{"(a = 1 && b > 0){}"}, &Query{filters: []*Filter{&Filter{key: "a", op: "=", val: 1}, &Filter{key: "b", op: ">", val: 0}}}, false},
+		{"filter  and retrieve", args{"(a = 1 && b > 0){a,b,c{x,y,z}}"}, &Query{filters: []*Filter{&Filter{key: "a", op: "=", val: 1}, &Filter{key: "b", op: ">", val: 0}}, fields: append(fields("a", "b"), field{name: "c", path: []string{"c"}, query: &Query{fields: fields("x", "y", "z")}})}, false},
+		{"comma in value", args{`(a = "x,y"){}`}, &Query{filters: []*Filter{&Filter{key: "a", op: "=", val: "x,y"}}}, false},
+		{"comma in value", args{"(a = x,y){}"}, nil, true},
+		{"retrieve path", args{"{a.b, c}"}, &Query{fields: fields("a.b", "c")}, false},
+		{"retrieve alias", args{"{fullName: name.fullName, mail : email}"}, &Query{fields: []field{{name: "fullName", path: []string{"name", "fullName"}}, {name: "mail", path: []string{"email"}}}}, false},
+		{"block alias", args{"{p: person{name}}"}, &Query{fields: []field{{name: "p", path: []string{"person"}, query: &Query{fields: fields("name")}}}}, false},
+		{"retrieve alias", args{"{a:}"}, nil, true},
+		{"retrieve alias", args{"{a: b: c}"}, nil, true},
+		{"retrieve alias", args{"{a..b}"}, nil, true},
+		{"wildcard", args{"{*}"}, &Query{all: true}, false},
+		{"wildcard exclusion", args{"{*, -password, -token}"}, &Query{all: true, exclude: []string{"password", "token"}}, false},
+		{"wildcard exclusion", args{"{-password, *, a{b}}"}, &Query{all: true, exclude: []string{"password"}, fields: []field{{name: "a", path: []string{"a"}, query: &Query{fields: fields("b")}}}}, false},
+		{"wildcard exclusion", args{"{a, -password}"}, nil, true},
+		{"wildcard exclusion", args{"{*, -}"}, nil, true},
+		{"recursive", args{"{..id, ids: ..id, ..user{name}}"}, &Query{fields: []field{{name: "id", path: []string{"id"}, deep: true}, {name: "ids", path: []string{"id"}, deep: true}, {name: "user", path: []string{"user"}, deep: true, query: &Query{fields: fields("name")}}}}, false},
+		{"recursive", args{"{..}"}, nil, true},
+		{"recursive", args{"{..a.b}"}, nil, true},
+		{"keys", args{"{Person{givenName}, items2, 205705993, été}"}, &Query{fields: append([]field{{name: "Person", path: []string{"Person"}, query: &Query{fields: fields("givenName")}}}, fields("items2", "205705993", "été")...)}, false},
+		{"quoted keys", args{`{"first name", "@timestamp", "a.b", user."e-mail", "x\"\u00e9": "*"}`}, &Query{fields: []field{{name: "first name", path: []string{"first name"}}, {name: "@timestamp", path: []string{"@timestamp"}}, {name: "a.b", path: []string{"a.b"}}, {name: "user.e-mail", path: []string{"user", "e-mail"}}, {name: "x\"é", path: []string{"*"}}}}, false},
+		{"quoted keys", args{`{"my users"(id > 1){.."@id"}, *, -"pass word"}`}, &Query{stillFilters: true, all: true, allAt: 1, exclude: []string{"pass word"}, fields: []field{{name: "my users", path: []string{"my users"}, query: &Query{stillFilters: true, filters: []*Filter{&Filter{key: "id", op: ">", val: 1}}, fields: []field{{name: "@id", path: []string{"@id"}, deep: true}}}}}}, false},
+		{"quoted keys", args{`{"a\q"}`}, nil, true},
+		{"quoted keys", args{`{"a}`}, nil, true},
+		{"quoted keys", args{`{"a" b}`}, nil, true},
+		{"quoted keys", args{`{a."b".c{d}}`}, nil, true},
+		{"selectors", args{"{items[0]{name}, last: items[-1], s: items[2:10], h: a.b[:3], t: ..c[-2:], all: items[ : ]}"}, &Query{fields: []field{{name: "items", path: []string{"items"}, sel: &selector{hasLow: true}, query: &Query{fields: fields("name")}}, {name: "last", path: []string{"items"}, sel: &selector{low: -1, hasLow: true}}, {name: "s", path: []string{"items"}, sel: &selector{low: 2, high: 10, slice: true, hasLow: true, hasHigh: true}}, {name: "h", path: []string{"a", "b"}, sel: &selector{high: 3, slice: true, hasHigh: true}}, {name: "t", path: []string{"c"}, deep: true, sel: &selector{low: -2, slice: true, hasLow: true}}, {name: "all", path: []string{"items"}, sel: &selector{slice: true}}}}, false},
+		{"arguments", args{"users(order_by: id desc, limit: 20, offset: 40){username}"}, &Query{fields: fields("username"), order: []orderKey{{key: "id", desc: true}}, limit: 20, limited: true, offset: 40}, false},
+		{"arguments", args{`(a > 1, order_by: [score desc, "full name" asc, b.c], b < 2, limit: 0){}`}, &Query{stillFilters: true, filters: []*Filter{&Filter{key: "a", op: ">", val: 1}, &Filter{key: "b", op: "<", val: 2}}, order: []orderKey{{key: "score", desc: true}, {key: `"full name"`}, {key: "b.c"}}, limited: true}, false},
+		{"arguments", args{`("limit" : 1 && offset > 2){}`}, &Query{filters: []*Filter{&Filter{key: `"limit"`, op: ":", val: 1}, &Filter{key: "offset", op: ">", val: 2}}}, false},
+		{"arguments", args{"users(){n}"}, &Query{fields: fields("n")}, false},
+		{"arguments", args{"{a( ){b}}"}, &Query{fields: []field{{name: "a", path: []string{"a"}, query: &Query{fields: fields("b")}}}}, false},
+		{"arguments", args{"(limit: -1){}"}, nil, true},
+		{"arguments", args{"(limit: a){}"}, nil, true},
+		{"arguments", args{"(limit: 1, limit: 2){}"}, nil, true},
+		{"arguments", args{"(order_by: ){}"}, nil, true},
+		{"arguments", args{"(order_by: [a, ){}"}, nil, true},
+		{"arguments", args{"(a > 1,){}"}, nil, true},
+		{"arguments", args{"(order_by: a b){}"}, nil, true},
+		{"selectors", args{"{items[]}"}, nil, true},
+		{"selectors", args{"{items[a]}"}, nil, true},
+		{"selectors", args{"{items[1:2:3]}"}, nil, true},
+		{"selectors", args{"{items[0}"}, nil, true},
+		{"selectors", args{"{items[0], items[1]}"}, nil, true},
+		{"string values", args{`(a = "AT&T (US)" && b : "line\nbreak" && c != "\u00e9\"{}" && d = "42" && e = 42 && f = "true"){}`}, &Query{filters: []*Filter{&Filter{key: "a", op: "=", val: "AT&T (US)"}, &Filter{key: "b", op: ":", val: "line\nbreak"}, &Filter{key: "c", op: "!=", val: "é\"{}"}, &Filter{key: "d", op: "=", val: "42"}, &Filter{key: "e", op: "=", val: 42}, &Filter{key: "f", op: "=", val: "true"}}}, false},
+		{"string values", args{`(a in ["1", 1, "a,b]"] && b between ["1".."9"]){}`}, &Query{filters: []*Filter{&Filter{key: "a", op: "in", val: []interface{}{"1", 1, "a,b]"}}, &Filter{key: "b", op: "between", val: valueRange{low: "1", high: "9"}}}}, false},
+		{"string values", args{`(a = "\x"){}`}, nil, true},
+		{"string values", args{`(a = "x\"){}`}, nil, true},
+		{"quoted filter keys", args{`("first name" = x && has(a."b c") && type("@t") = string){}`}, &Query{filters: []*Filter{&Filter{key: `"first name"`, op: "=", val: "x"}, &Filter{key: `a."b c"`, op: "has"}, &Filter{key: `"@t"`, op: "=", val: "string", typeOf: true}}}, false},
+		{"missing directive", args{"@missing(null) {a}"}, &Query{fields: fields("a"), missing: MissingNull}, false},
+		{"missing directive", args{"@missing( strict ) users{a}"}, &Query{fields: fields("a"), missing: MissingStrict}, false},
+		{"missing directive", args{"@missing(omit) {a}"}, &Query{fields: fields("a")}, false},
+		{"missing directive", args{"@missing(none) {a}"}, nil, true},
+		{"missing directive", args{"@missing {a}"}, nil, true},
+		{"missing directive", args{"@unknown(null) {a}"}, nil, true},
+		{"missing directive", args{"{a} @missing(null)"}, nil, true},
 		{"retrieve only", args{"{"}, nil, true},
 		{"retrieve only", args{"{a,b,c"}, nil, true},
 		{"filter only", args{"( : 1){}"}, nil, true},
 		{"filter only", args{"(a:){}"}, nil, true},
 		{"filter only", args{"(a ::: 1){}"}, nil, true},
-		{"filter only", args{"(a>1 || b < c){}"}, nil, true},
+		{"filter path", args{"(a.b.0 = 1){}"}, &Query{filters: []*Filter{&Filter{key: "a.b.0", op: "=", val: 1}}}, false},
+		{"filter only", args{"(a..b = 1){}"}, nil, true},
+		{"filter only", args{"(a. = 1){}"}, nil, true},
+		{"filter or", args{"(a>1 || b < c){}"}, &Query{filters: []*Filter{&Filter{op: "||", sub: []*Filter{&Filter{key: "a", op: ">", val: 1}, &Filter{key: "b", op: "<", val: "c"}}}}}, false},
+		{"filter not and group", args{"(!(a = 1) && (b = 2 || c = 3)){}"}, &Query{filters: []*Filter{&Filter{op: "!", sub: []*Filter{&Filter{key: "a", op: "=", val: 1}}}, &Filter{op: "||", sub: []*Filter{&Filter{key: "b", op: "=", val: 2}, &Filter{key: "c", op: "=", val: 3}}}}}, false},
+		{"filter precedence", args{"(a = 1 || b = 2 && c = 3){}"}, &Query{filters: []*Filter{&Filter{op: "||", sub: []*Filter{&Filter{key: "a", op: "=", val: 1}, &Filter{op: "&&", sub: []*Filter{&Filter{key: "b", op: "=", val: 2}, &Filter{key: "c", op: "=", val: 3}}}}}}}, false},
+		{"filter only", args{"(a>1 ||){}"}, nil, true},
+		{"filter only", args{"((a>1){}"}, nil, true},
+		{"filter only", args{"(!){}"}, nil, true},
+		{"filter only", args{"(a>1 | b < c){}"}, nil, true},
 		{"filter only", args{"(a > 1{}"}, nil, true},
 		{"filter only", args{"a<1){}"}, nil, true},
 		{"filter only", args{"(a){}"}, nil, true},
@@ -94,3 +193,637 @@ func TestParseQuery(t *testing.T) {
 		})
 	}
 }
+
+func TestCheckFilterExpression(t *testing.T) {
+	f := func(query, json string, expected bool) {
+		t.Helper()
+		var p Parser
+		v, err := p.Parse(json)
+		if err != nil {
+			t.Fatalf("cannot parse json %s: %s", json, err)
+		}
+		err = v.Check(*MustParseQuery(query))
+		if (err == nil) != expected {
+			t.Fatalf("unexpected Check result for %s on %s; got %v; want %v", query, json, err == nil, expected)
+		}
+	}
+	q := `((status = "open" || status = "pending") && !(owner = null)){}`
+	f(q, `{"status":"open","owner":"bob"}`, true)
+	f(q, `{"status":"pending","owner":"bob"}`, true)
+	f(q, `{"status":"closed","owner":"bob"}`, false)
+	f(q, `{"status":"open","owner":null}`, false)
+
+	// Nested paths.
+	doc := `{"author":{"profile":{"country":"FR"},"tags":["a","b"]}}`
+	f(`(author.profile.country = "FR"){}`, doc, true)
+	f(`(author.profile.country = "US"){}`, doc, false)
+	f(`(author.tags.1 = b){}`, doc, true)
+	f(`(author.tags.0 = b){}`, doc, false)
+	f(`(author.profile.city = Paris){}`, doc, true)
+
+	// Comparisons on missing keys are ignored.
+	f(`(a = 1 || b = 2){}`, `{"b":3}`, false)
+	f(`(a = 1 || b = 2){}`, `{"b":2}`, true)
+	f(`(!(a = 1)){}`, `{"b":2}`, true)
+	f(`(a = 1 && b = 2){}`, `{"c":2}`, true)
+
+	// Contain and like ignore case, unless their '=' variant is used.
+	sku := `{"sku":"AbC-123x"}`
+	f(`(sku : abc){}`, sku, true)
+	f(`(sku := abc){}`, sku, false)
+	f(`(sku := AbC){}`, sku, true)
+	f(`(sku !: abc){}`, sku, false)
+	f(`(sku !:= abc){}`, sku, true)
+	f(`(sku :: ^abc-\d+X$){}`, sku, true)
+	f(`(sku ::= ^abc-\d+X$){}`, sku, false)
+	f(`(sku ::= ^AbC-\d+x$){}`, sku, true)
+	f(`(sku !:: ^abc){}`, sku, false)
+	f(`(sku !::= ^abc){}`, sku, true)
+	f(`(sku :: "\\D{3}"){}`, sku, true)
+	f(`(sku :: 123){}`, sku, true)
+	f(`(sku !:: 123){}`, sku, false)
+	f(`(sku :: abc){}`, `{"sku":42}`, false)
+
+	// Quoted values are strings with JSON escapes.
+	f(`(a = "42"){}`, `{"a":"42"}`, true)
+	f(`(a = "42"){}`, `{"a":42}`, false)
+	f(`(a = 42){}`, `{"a":"42"}`, false)
+	f(`(a = "AT&T (US)"){}`, `{"a":"AT&T (US)"}`, true)
+	f(`(a = "line\nbreak"){}`, `{"a":"line\nbreak"}`, true)
+	f(`(a = "\u00e9"){}`, `{"a":"é"}`, true)
+	f(`(a = "\"q\""){}`, `{"a":"\"q\""}`, true)
+	f(`(a = "\"q\""){}`, `{"a":"q"}`, false)
+	f(`(a : "\"q"){}`, `{"a":"say \"Q\""}`, true)
+	f(`(a : "\"q"){}`, `{"a":"say q"}`, false)
+	f(`(a :: "^a\\.b$"){}`, `{"a":"a.b"}`, true)
+	f(`(a :: "^a\\.b$"){}`, `{"a":"axb"}`, false)
+	f(`(a in ["1", "2"]){}`, `{"a":1}`, false)
+	f(`(a in ["1", "2"]){}`, `{"a":"1"}`, true)
+
+	// Set membership.
+	q = `(status in ["open", "pending", "blocked"]){}`
+	f(q, `{"status":"open"}`, true)
+	f(q, `{"status":"blocked"}`, true)
+	f(q, `{"status":"closed"}`, false)
+	f(q, `{"status":1}`, false)
+	f(`(status not in [open, pending]){}`, `{"status":"closed"}`, true)
+	f(`(status not in [open, pending]){}`, `{"status":"open"}`, false)
+	f(`(id in [1, 2.5, 3]){}`, `{"id":2.5}`, true)
+	f(`(id in [1, 2.5, 3]){}`, `{"id":3}`, true)
+	f(`(id in [1, 2.5, 3]){}`, `{"id":4}`, false)
+	f(`(ok in [true]){}`, `{"ok":true}`, true)
+	f(`(ok in [null, false]){}`, `{"ok":null}`, true)
+	f(`(ok in []){}`, `{"ok":null}`, false)
+	f(`(ok in [true] || id in [1]){}`, `{"ok":false,"id":1}`, true)
+
+	// Ranges.
+	q = `(price in [10..50)){}`
+	f(q, `{"price":10}`, true)
+	f(q, `{"price":49.99}`, true)
+	f(q, `{"price":50}`, false)
+	f(q, `{"price":9.5}`, false)
+	f(q, `{"price":"20"}`, false)
+	q = `(price between (10..50]){}`
+	f(q, `{"price":10}`, false)
+	f(q, `{"price":50}`, true)
+	f(q, `{"price":10.5}`, true)
+	f(`(price between [0.5..1.5]){}`, `{"price":1}`, true)
+	f(`(price not in [10..50)){}`, `{"price":50}`, true)
+	f(`(price not in [10..50)){}`, `{"price":20}`, false)
+	f(`(price not between [10..50)){}`, `{"price":"x"}`, false)
+	f(`(name between ["a".."m")){}`, `{"name":"bob"}`, true)
+	f(`(name between ["a".."m")){}`, `{"name":"m"}`, false)
+	f(`(name between [a..m]){}`, `{"name":"m"}`, true)
+	f(`(name between (a..m]){}`, `{"name":"a"}`, false)
+	// Existence and type predicates.
+	doc = `{"tags":["a"],"owner":null,"n":1,"geo":{"lat":1}}`
+	f(`(has(tags)){}`, doc, true)
+	f(`(has(missing)){}`, doc, false)
+	f(`(!has(missing)){}`, doc, true)
+	f(`(!has(owner)){}`, doc, false)
+	f(`(has(geo.lat) && !has(geo.lng)){}`, doc, true)
+	f(`(type(tags) = "array"){}`, doc, true)
+	f(`(type(tags) = object){}`, doc, false)
+	f(`(type(owner) = null && type(n) = number && type(geo) != array){}`, doc, true)
+	f(`(type(n) in [string, number]){}`, doc, true)
+	f(`(type(missing) = array){}`, doc, false)
+	f(`(type(missing) in [array, object]){}`, doc, false)
+	f(`(type(missing) != array){}`, doc, true)
+	f(`(type(missing) not in [array]){}`, doc, true)
+	f(`(!(type(missing) = array)){}`, doc, true)
+	f(`(has(missing) && type(missing) = array){}`, doc, false)
+	f(`{geo(has(lat)){}}`, doc, true)
+	f(`{geo(has(lng)){}}`, doc, false)
+
+	f(`(name >= bob){}`, `{"name":"bob"}`, true)
+	f(`(name >= bob){}`, `{"name":"alice"}`, false)
+	f(`(name <= bob){}`, `{"name":"alice"}`, true)
+	f(`(name <= bob){}`, `{"name":"carl"}`, false)
+}
+
+// testKeep checks the results of Keep and Retrieve for query on v, and
+// that KeepTo and RetrieveTo write the same. expectedRetrieve is the
+// expected result of Retrieve when it differs from the one of Keep.
+func testKeep(t *testing.T, v *Value, query, expectedKeep, expectedRetrieve string) {
+	t.Helper()
+	if len(expectedRetrieve) == 0 {
+		expectedRetrieve = expectedKeep
+	}
+	request := MustParseQuery(query)
+	for _, r := range []struct {
+		name     string
+		apply    func(Query) (string, error)
+		applyTo  func(io.Writer, Query) error
+		expected string
+	}{
+		{"Keep", v.Keep, v.KeepTo, expectedKeep},
+		{"Retrieve", v.Retrieve, v.RetrieveTo, expectedRetrieve},
+	} {
+		s, err := r.apply(*request)
+		if err != nil {
+			t.Fatalf("unexpected error on %s for %s: %s", r.name, query, err)
+		}
+		if s != r.expected {
+			t.Fatalf("unexpected %s result for %s; got %s; want %s", r.name, query, s, r.expected)
+		}
+		var w bytes.Buffer
+		if err := r.applyTo(&w, *request); err != nil {
+			t.Fatalf("unexpected error on %sTo for %s: %s", r.name, query, err)
+		}
+		if w.String() != s {
+			t.Fatalf("unexpected %sTo result for %s; got %s; want %s", r.name, query, w.String(), s)
+		}
+	}
+}
+
+func TestKeep(t *testing.T) {
+	type keepCase struct {
+		query    string
+		keep     string
+		retrieve string
+	}
+	tests := []struct {
+		name  string
+		doc   string
+		cases []keepCase
+	}{
+		{"alias", `{"name":{"fullName":"Leonid Bugaev"},"email":"leonid@example.com","person":{"id":1,"age":30}}`, []keepCase{
+			{`{fullName: name.fullName, mail: email}`, `{"fullName":"Leonid Bugaev","mail":"leonid@example.com"}`, ""},
+			{`{user: person{id}}`, `{"user":{"id":1}}`, ""},
+		}},
+		{"commas", `{"items":[{"id":1},{"id":2},{"id":3}],"a":1,"c":3}`, []keepCase{
+			{`{items(id < 3){id}}`, `{"items":[{"id":1},{"id":2}]}`, ""},
+			{`{items(id > 1){id}}`, `{"items":[{"id":2},{"id":3}]}`, ""},
+			{`{items(id = 9){id}}`, `{"items":[]}`, ""},
+			{`{a, b, c}`, `{"a":1,"c":3}`, ""},
+			{`{b, a}`, `{"a":1}`, ""},
+			{`(a = 2){a}`, ``, `{"a":1}`},
+		}},
+		{"order", `{"d":{"y":4,"x":5},"c":3,"b":{"x":2},"a":1,"e":6}`, []keepCase{
+			{`{a, b{x}, c, d{y, x}}`, `{"a":1,"b":{"x":2},"c":3,"d":{"y":4,"x":5}}`, ""},
+			{`{d{x, y}, c, b{x}, a}`, `{"d":{"x":5,"y":4},"c":3,"b":{"x":2},"a":1}`, ""},
+			{`{z: e, y: d{x}, x: a}`, `{"z":6,"y":{"x":5},"x":1}`, ""},
+			{`{e, *, -c, b{x}}`, `{"e":6,"d":{"y":4,"x":5},"a":1,"b":{"x":2}}`, ""},
+			{`{b{x}, a, *}`, `{"b":{"x":2},"a":1,"d":{"y":4,"x":5},"c":3,"e":6}`, ""},
+		}},
+		{"keys", `{"Person":{"givenName":"Ann","first name":"Ann","@timestamp":1},"items2":[{"a.b":1,"a":{"b":2}}],"123":"x","é\"":true}`, []keepCase{
+			{`{Person{givenName}}`, `{"Person":{"givenName":"Ann"}}`, ""},
+			{`{Person{"first name", "@timestamp"}}`, `{"Person":{"first name":"Ann","@timestamp":1}}`, ""},
+			{`{name: Person."first name", 123}`, `{"name":"Ann","123":"x"}`, ""},
+			{`{items2{"a.b", ab: a.b}}`, `{"items2":[{"a.b":1,"ab":2}]}`, ""},
+			{`{items2{x: "a.b", y: "a"."b"}}`, `{"items2":[{"x":1,"y":2}]}`, ""},
+			{`{"\u00e9\"", "my key": "123"}`, `{"é\"":true,"my key":"x"}`, ""},
+			{`{..givenName}`, `{"givenName":["Ann"]}`, ""},
+			{`{*, -Person, -"é\"", -items2}`, `{"123":"x"}`, ""},
+			{`("Person"."first name" = Ann){123}`, `{"123":"x"}`, ""},
+			{`(Person."@timestamp" > 1){123}`, ``, `{"123":"x"}`},
+			{`{"Person"(has("@timestamp")){givenName}}`, `{"Person":{"givenName":"Ann"}}`, ""},
+		}},
+		{"selectors", `{"items":[{"id":0,"name":"a"},{"id":1,"name":"b"},{"id":2,"name":"c"},{"id":3,"name":"d"}],"n":1}`, []keepCase{
+			{`{items[0]{name}}`, `{"items":{"name":"a"}}`, ""},
+			{`{items[-1]{name}}`, `{"items":{"name":"d"}}`, ""},
+			{`{items[1:3]{name}}`, `{"items":[{"name":"b"},{"name":"c"}]}`, ""},
+			{`{items[:2]{id}}`, `{"items":[{"id":0},{"id":1}]}`, ""},
+			{`{items[-2:]{id}}`, `{"items":[{"id":2},{"id":3}]}`, ""},
+			{`{items[2:100]{id}}`, `{"items":[{"id":2},{"id":3}]}`, ""},
+			{`{items[3:1]{id}}`, `{"items":[]}`, ""},
+			{`{items[1:](id > 1){id}}`, `{"items":[{"id":2},{"id":3}]}`, ""},
+			{`{items[0](id > 1){id}}`, `{}`, ""},
+			{`{items[4]{id}, n}`, `{"n":1}`, ""},
+			{`{m: n[0], n}`, `{"n":1}`, ""},
+			{`{first: items.0.name, last: items[-1]}`, `{"first":"a","last":{"id":3,"name":"d"}}`, ""},
+			{`{first: items[0], names: ..name[1:3]}`, `{"first":{"id":0,"name":"a"},"names":["b","c"]}`, ""},
+		}},
+		{"paging", `{"users":[
+			{"id":3,"name":"c","score":2},
+			{"id":1,"name":"a","score":5},
+			{"id":4,"name":"B","score":2},
+			{"id":2,"name":"d"},
+			{"id":5,"name":"e","score":"x"}
+		]}`, []keepCase{
+			{`{users(order_by: id){id}}`, `{"users":[{"id":1},{"id":2},{"id":3},{"id":4},{"id":5}]}`, ""},
+			{`{users(order_by: id desc){id}}`, `{"users":[{"id":5},{"id":4},{"id":3},{"id":2},{"id":1}]}`, ""},
+			{`{users(order_by: name asc){name}}`, `{"users":[{"name":"B"},{"name":"a"},{"name":"c"},{"name":"d"},{"name":"e"}]}`, ""},
+			{`{users(order_by: [score desc, id]){id}}`, `{"users":[{"id":5},{"id":1},{"id":3},{"id":4},{"id":2}]}`, ""},
+			{`{users(order_by: [score, id desc]){id}}`, `{"users":[{"id":4},{"id":3},{"id":1},{"id":5},{"id":2}]}`, ""},
+			{`{users(limit: 2){id}}`, `{"users":[{"id":3},{"id":1}]}`, ""},
+			{`{users(offset: 3){id}}`, `{"users":[{"id":2},{"id":5}]}`, ""},
+			{`{users(offset: 5){id}}`, `{"users":[]}`, ""},
+			{`{users(limit: 0){id}}`, `{"users":[]}`, ""},
+			{`{users(order_by: id desc, limit: 2, offset: 1){id}}`, `{"users":[{"id":4},{"id":3}]}`, ""},
+			{`{users(id > 1, order_by: id, limit: 2){id}}`, `{"users":[{"id":2},{"id":3}]}`, ""},
+			{`{users[1:](order_by: id, limit: 2){id}}`, `{"users":[{"id":1},{"id":2}]}`, ""},
+			{`{users(order_by: id, offset: 1){*, -score}}`, `{"users":[{"id":2,"name":"d"},{"id":3,"name":"c"},{"id":4,"name":"B"},{"id":5,"name":"e"}]}`, ""},
+			{`(order_by: id desc, limit: 1){users{id}}`, `{"users":[{"id":3},{"id":1},{"id":4},{"id":2},{"id":5}]}`, ""},
+		}},
+		// Arguments of the root query apply to root arrays.
+		{"root paging", `[{"v":3},{"v":"b"},{},{"v":null},{"v":1},{"v":"a"},{"v":true},{"v":false},{"v":{}},{"v":[2]},5]`, []keepCase{
+			{`(order_by: v){v}`, `[{"v":null},{"v":false},{"v":true},{"v":1},{"v":3},{"v":"a"},{"v":"b"},{"v":[2]},{"v":{}},{},5]`, ""},
+			{`(order_by: v desc, limit: 3){v}`, `[{"v":{}},{"v":[2]},{"v":"b"}]`, ""},
+			{`(offset: 9){v}`, `[{"v":[2]},5]`, ""},
+		}},
+		{"wildcard", `[{"id":1,"name":"foo","password":"x","token":"y","geo":{"lat":1,"lng":2}},{"id":2,"name":"bar"}]`, []keepCase{
+			{`{*}`, `[{"id":1,"name":"foo","password":"x","token":"y","geo":{"lat":1,"lng":2}},{"id":2,"name":"bar"}]`, ""},
+			{`{*, -password, -token}`, `[{"id":1,"name":"foo","geo":{"lat":1,"lng":2}},{"id":2,"name":"bar"}]`, ""},
+			{`{*, -password, -token, -name, -geo}`, `[{"id":1},{"id":2}]`, ""},
+			{`{*, -password, -token, -name, -geo, id: name}`, `[{"id":"foo"},{"id":"bar"}]`, ""},
+		}},
+		{"wildcard object", `{"id":1,"name":"foo","geo":{"lat":1,"lng":2}}`, []keepCase{
+			{`{*, geo{lat}}`, `{"id":1,"name":"foo","geo":{"lat":1}}`, ""},
+			// Aliased keys are renamed, not copied.
+			{`{*, n: name}`, `{"id":1,"geo":{"lat":1,"lng":2},"n":"foo"}`, ""},
+			{`{pos: geo{lat}, *}`, `{"pos":{"lat":1},"id":1,"name":"foo"}`, ""},
+			{`{*, lat: geo.lat}`, `{"id":1,"name":"foo","geo":{"lat":1,"lng":2},"lat":1}`, ""},
+			{`{*, ids: ..id}`, `{"id":1,"name":"foo","geo":{"lat":1,"lng":2},"ids":[1]}`, ""},
+		}},
+		{"recursive", `{"id":1,"user":{"id":2,"name":"foo"},"posts":[{"id":3,"user":{"id":4,"name":"bar"}}]}`, []keepCase{
+			{`{..id}`, `{"id":[1,2,3,4]}`, ""},
+			{`{ids: ..id, ..missing}`, `{"ids":[1,2,3,4],"missing":[]}`, ""},
+			{`{..user{name}}`, `{"user":[{"name":"foo"},{"name":"bar"}]}`, ""},
+			{`{..user(id > 2){name}}`, `{"user":[{"name":"bar"}]}`, ""},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var p Parser
+			v, err := p.Parse(tt.doc)
+			if err != nil {
+				t.Fatalf("cannot parse json: %s", err)
+			}
+			for _, c := range tt.cases {
+				testKeep(t, v, c.query, c.keep, c.retrieve)
+			}
+		})
+	}
+}
+
+func TestKeepTo(t *testing.T) {
+	var p Parser
+	v, err := p.Parse(mediumFixture)
+	if err != nil {
+		t.Fatalf("cannot parse json: %s", err)
+	}
+	f := func(query string) {
+		t.Helper()
+		request := MustParseQuery(query)
+
+		expected, err := v.Keep(*request)
+		if err != nil {
+			t.Fatalf("unexpected error on Keep: %s", err)
+		}
+		var w bytes.Buffer
+		if err := v.KeepTo(&w, *request); err != nil {
+			t.Fatalf("unexpected error on KeepTo: %s", err)
+		}
+		if w.String() != expected {
+			t.Fatalf("unexpected KeepTo result for %s; got %s; want %s", query, w.String(), expected)
+		}
+
+		expected, err = v.Retrieve(*request)
+		if err != nil {
+			t.Fatalf("unexpected error on Retrieve: %s", err)
+		}
+		w.Reset()
+		if err := v.RetrieveTo(&w, *request); err != nil {
+			t.Fatalf("unexpected error on RetrieveTo: %s", err)
+		}
+		if w.String() != expected {
+			t.Fatalf("unexpected RetrieveTo result for %s; got %s; want %s", query, w.String(), expected)
+		}
+	}
+	f(`{person{name{fullName}}}`)
+	f(`{person{email,geo{city,state},bio}}`)
+	f(`{company{*, -geo}}`)
+	f(`{..fullName}`)
+}
+
+func TestKeepSelectors(t *testing.T) {
+	var p Parser
+	v, err := p.Parse(`{"items":[{"id":0,"name":"a"},{"id":1,"name":"b"},{"id":2,"name":"c"},{"id":3,"name":"d"}],"n":1}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	check := func(query string, expected bool) {
+		t.Helper()
+		if err := v.Check(*MustParseQuery(query)); (err == nil) != expected {
+			t.Fatalf("unexpected Check result for %s; got %v; want %v", query, err, expected)
+		}
+	}
+	check(`{items(id = 3){id}}`, true)
+	check(`{items[0](id = 3){id}}`, false)
+	check(`{items[-1](id = 3){id}}`, true)
+	check(`{items[:3](id = 3){id}}`, false)
+	check(`{items[1:](id = 3){id}}`, true)
+	check(`{items[9](id = 3){id}}`, true)
+
+	// Missing keys are reported under the indexes of the document.
+	request := MustParseQuery(`{items[4]{id}, a: items[1:]{x}, b: items[-1]{x}, c: items[2:](id > 2){x}}`)
+	request.SetMissing(MissingStrict)
+	if _, err := v.Keep(*request); err == nil || err.Error() != "missing keys: items[4], items.1.x, items.2.x, items.3.x, items.3.x, items.3.x" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestKeepMissing(t *testing.T) {
+	var p Parser
+	v, err := p.Parse(`{"users":[{"id":1,"name":"foo","geo":{"city":"x"}},{"id":2}]}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	f := func(query, expected, expectedErr string) {
+		t.Helper()
+		request := MustParseQuery(query)
+		for _, apply := range []func(Query) (string, error){v.Keep, v.Retrieve} {
+			s, err := apply(*request)
+			if len(expectedErr) > 0 {
+				if err == nil || err.Error() != expectedErr {
+					t.Fatalf("unexpected error for %s; got %v; want %s", query, err, expectedErr)
+				}
+				if _, ok := err.(*MissingKeysError); !ok {
+					t.Fatalf("unexpected error type for %s; got %T; want *MissingKeysError", query, err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("unexpected error for %s: %s", query, err)
+			}
+			if s != expected {
+				t.Fatalf("unexpected result for %s; got %s; want %s", query, s, expected)
+			}
+		}
+	}
+	f(`{users{id, name, city: geo.city, geo{city}}}`,
+		`{"users":[{"id":1,"name":"foo","city":"x","geo":{"city":"x"}},{"id":2}]}`, "")
+	f(`@missing(null) {users{id, name, city: geo.city, geo{city}}}`,
+		`{"users":[{"id":1,"name":"foo","city":"x","geo":{"city":"x"}},{"id":2,"name":null,"city":null,"geo":null}]}`, "")
+	f(`@missing(strict) {users{id, name, city: geo.city, geo{city}}}`,
+		"", "missing keys: users.1.name, users.1.geo.city, users.1.geo")
+	f(`@missing(strict) {users{id}}`, `{"users":[{"id":1},{"id":2}]}`, "")
+
+	request := MustParseQuery(`{users{name}, total}`)
+	request.SetMissing(MissingNull)
+	s, err := v.Keep(*request)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if expected := `{"users":[{"name":"foo"},{"name":null}],"total":null}`; s != expected {
+		t.Fatalf("unexpected result; got %s; want %s", s, expected)
+	}
+
+	if err := v.Check(*MustParseQuery(`{users{geo(city = x){}}}`)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+func TestSearchMissing(t *testing.T) {
+	var p Parser
+	v, err := p.Parse(`{"users":[{"id":1,"geo":{"lat":2}},{"id":3}]}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	values, err := v.SearchMissing(MissingOmit, "users", "geo", "lat")
+	if err != nil || len(values) != 1 || values[0] != float64(2) {
+		t.Fatalf("unexpected result; got %v, %v; want [2]", values, err)
+	}
+	values, err = v.SearchMissing(MissingNull, "users", "geo", "lat")
+	if err != nil || len(values) != 2 || values[0] != float64(2) || values[1] != nil {
+		t.Fatalf("unexpected result; got %v, %v; want [2 <nil>]", values, err)
+	}
+	_, err = v.Search("users", "geo", "lat")
+	if err == nil || err.Error() != "missing keys: users.1.geo" {
+		t.Fatalf("unexpected error; got %v; want missing keys: users.1.geo", err)
+	}
+
+	// Present null values are nil whatever the policy.
+	v, err = p.Parse(`{"users":[{"geo":null},{}],"o":{"k":1}}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	values, err = v.SearchMissing(MissingNull, "users", "geo")
+	if err != nil || len(values) != 2 || values[0] != nil || values[1] != nil {
+		t.Fatalf("unexpected result; got %v, %v; want [<nil> <nil>]", values, err)
+	}
+	values, err = v.SearchMissing(MissingOmit, "users", "geo")
+	if err != nil || len(values) != 1 || values[0] != nil {
+		t.Fatalf("unexpected result; got %v, %v; want [<nil>]", values, err)
+	}
+
+	// Objects at the end of the path are returned as is.
+	values, err = v.Search("o")
+	if err != nil || len(values) != 1 {
+		t.Fatalf("unexpected result; got %v, %v; want one object", values, err)
+	}
+	if o, ok := values[0].(*Value); !ok || o.GetInt("k") != 1 {
+		t.Fatalf("unexpected value; got %v; want {\"k\":1}", values[0])
+	}
+	values, err = v.Search("users")
+	if err != nil || len(values) != 2 {
+		t.Fatalf("unexpected result; got %v, %v; want two objects", values, err)
+	}
+	if s := values[0].(*Value).String(); s != `{"geo":null}` {
+		t.Fatalf("unexpected value; got %s; want {\"geo\":null}", s)
+	}
+}
+
+func TestKeepValidJSON(t *testing.T) {
+	f := func(fixture string, queries ...string) {
+		t.Helper()
+		var p Parser
+		for _, query := range queries {
+			request := MustParseQuery(query)
+			for _, apply := range []string{"Keep", "Retrieve"} {
+				v, err := p.Parse(fixture)
+				if err != nil {
+					t.Fatalf("cannot parse json: %s", err)
+				}
+				var s string
+				if apply == "Keep" {
+					s, err = v.Keep(*request)
+				} else {
+					s, err = v.Retrieve(*request)
+				}
+				if err != nil {
+					t.Fatalf("unexpected error on %s for %s: %s", apply, query, err)
+				}
+				if len(s) == 0 {
+					continue
+				}
+				var p2 Parser
+				if _, err := p2.Parse(s); err != nil {
+					t.Fatalf("invalid JSON returned by %s for %s: %s\n%s", apply, query, err, s)
+				}
+			}
+		}
+	}
+	f(`{"s":"a\"b\\c\u0001\u2028é","n":1.50,"e":1e400,"o":{},"a":[[],{}],"t":true,"z":null}`,
+		`{s, n, e, o, a, t, z, missing}`,
+		`{*}`,
+		`{..s, ..missing}`,
+		`(n > 1){s}`,
+		`(n > 2){s}`,
+	)
+	f(smallFixture,
+		`(st >= 1){st}`,
+		`(gr = 0){st, sid, tt}`,
+		`{st, missing, sid, other}`,
+	)
+	f(mediumFixture,
+		`{person{name{fullName}}}`,
+		`{person{name{fullName}, email, geo{city, state}, bio}}`,
+		`{person{name}, company}`,
+		`{person{github(id > 1){handle}}}`,
+		`{person{github(id < 1){handle}, bio}}`,
+		`{person{*, -name, -geo}}`,
+	)
+	f(largeFixture,
+		`{users{username}}`,
+		`{users(id > 20){username}}`,
+		`{users(id < 0){username}}`,
+		`{topics{topics{title, fancy_title}}}`,
+		`{users{username}, topics{topics(visible = true){posters{description}}}}`,
+		`{topics{topics(posts_count > 1){id, missing, posters{user_id, missing}}}}`,
+		`{..username}`,
+		`{users[0:5]{username}, topics{topics[-1]{title}, first: topics[0]}}`,
+		`{users(order_by: id desc, limit: 20, offset: 40){username}}`,
+		`{users(order_by: [trust_level desc, username], limit: 5){id, username}}`,
+		`{topics{topics(visible = true, order_by: posts_count desc, offset: 2, limit: 3){title, posts_count}}}`,
+	)
+	f(twitterFixture,
+		`{statuses{text, user{name, screen_name, description}, entities}}`,
+		`{statuses(retweet_count > 0){id_str, user{*, -entities}}}`,
+		`{statuses{metadata, missing, geo}, search_metadata}`,
+	)
+	f(citmFixture,
+		`{events{*}, venueNames}`,
+		`{performances{id, seatCategories, missing}}`,
+		`{areaNames{205705993, "205705994"}, events{138586341{name, subTopicIds}}}`,
+		`{performances(eventId = 138586341){seatCategories{areas{areaId}}}}`,
+	)
+}
+
+func TestCheckRecursive(t *testing.T) {
+	var p Parser
+	v, err := p.Parse(`{"id":1,"user":{"id":2,"name":"foo"},"posts":[{"id":3,"user":{"id":4,"name":"bar"}}]}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := v.Check(*MustParseQuery(`{..user(name = bar){}}`)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := v.Check(*MustParseQuery(`{..user(name = baz){}}`)); err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+}
+
+func TestSearchDeep(t *testing.T) {
+	var p Parser
+	v, err := p.Parse(`{"id":1,"user":{"id":2},"posts":[{"id":3,"tags":[{"id":4}]}],"x":{"id":{"id":5}}}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var got []string
+	for _, m := range v.SearchDeep("id") {
+		got = append(got, strings.Join(m.Path, "/")+"="+m.Value.String())
+	}
+	expected := []string{"id=1", "user/id=2", "posts/0/id=3", "posts/0/tags/0/id=4", `x/id={"id":5}`, "x/id/id=5"}
+	if strings.Join(got, " ") != strings.Join(expected, " ") {
+		t.Fatalf("unexpected matches; got %q; want %q", got, expected)
+	}
+	if matches := v.SearchDeep("missing"); len(matches) != 0 {
+		t.Fatalf("unexpected matches for a missing key: %v", matches)
+	}
+}
+
+func TestParseQuerySyntaxError(t *testing.T) {
+	f := func(cmd string, expectedToken string, expectedLine, expectedColumn int) {
+		t.Helper()
+		_, err := ParseQuery(cmd)
+		if err == nil {
+			t.Fatalf("expecting non-nil error when parsing %q", cmd)
+		}
+		se, ok := err.(*SyntaxError)
+		if !ok {
+			t.Fatalf("unexpected error type for %q; got %T; want *SyntaxError", cmd, err)
+		}
+		if se.Token != expectedToken || se.Line != expectedLine || se.Column != expectedColumn {
+			t.Fatalf("unexpected error for %q; got %q at %d:%d; want %q at %d:%d (%s)",
+				cmd, se.Token, se.Line, se.Column, expectedToken, expectedLine, expectedColumn, se)
+		}
+		if cmd[se.Offset:se.Offset+len(se.Token)] != se.Token {
+			t.Fatalf("invalid offset %d for token %q in %q", se.Offset, se.Token, cmd)
+		}
+	}
+	f("{", "", 1, 2)
+	f("{a,b,c", "", 1, 7)
+	f("{a,,b}", ",", 1, 4)
+	f("(a ::: 1){}", ":", 1, 6)
+	f("(a > 1{}", "{", 1, 7)
+	f("(a === 1){}", "=", 1, 5)
+	f("(a !! 1){}", "!", 1, 5)
+	f("(a =! 1){}", "!", 1, 5)
+	f("(a <=> 1){}", ">", 1, 6)
+	f("(!!has(a)){}", "!", 1, 3)
+	f("(a ! 1){}", "!", 1, 4)
+	f("(a?1){}", ")", 1, 5)
+	f("{a, b{c(x & 1)}}", "&", 1, 11)
+	f("{a,\n  b{c(x = \"1)}}", `"1)}}`, 2, 11)
+	f("{é, b(c = 1 d)}", "d", 1, 13)
+	f("{a}}", "}", 1, 4)
+	f("(a = 1 && b :: x[0-){}", "x[0-", 1, 16)
+	f(`(b !::= "a(b"){}`, `"a(b"`, 1, 9)
+	f(`(b in [1, 2}`, `}`, 1, 12)
+	f(`(b not on [1]){}`, `on`, 1, 8)
+	f(`(a = "x\q" && b = 1){}`, `"x\q"`, 1, 6)
+	f(`{items[a]}`, `a`, 1, 8)
+	f(`(type(x) in [a..z]){}`, `in`, 1, 10)
+	f(`(type(x) not between [a..b]){}`, `not`, 1, 10)
+	f(`(type(x) : arr){}`, `:`, 1, 10)
+	f(`(type(x) >= array){}`, `>=`, 1, 10)
+	f(`(type(x) in [array, text]){}`, `text`, 1, 21)
+	f(`(type(x) != "list"){}`, `"list"`, 1, 13)
+	f(`{items[1:2:3]}`, `:`, 1, 11)
+	f("{a, b{x}, a: c}", "a", 1, 11)
+	f("{b{x}, b}", "b", 1, 8)
+	f("{*, a, *}", "*", 1, 8)
+
+	// Values missing in the middle of the query are reported at the
+	// character which follows them.
+	f("(a = ){}", ")", 1, 6)
+	f("(a between [1..]){a}", "]", 1, 16)
+	f("(a in [1, ]){}", "]", 1, 11)
+	f("(a = ", "", 1, 6)
+	for _, cmd := range []string{"(a = ){}", "(a between [1..]){a}"} {
+		if _, err := ParseQuery(cmd); err == nil || strings.Contains(err.Error(), "end of query") {
+			t.Fatalf("unexpected error for %q: %v", cmd, err)
+		}
+	}
+
+	// Invalid patterns are reported as written.
+	for _, op := range []string{"::", "!::", "::=", "!::="} {
+		cmd := `(a ` + op + ` "(") {}`
+		_, err := ParseQuery(cmd)
+		if err == nil || !strings.Contains(err.Error(), "invalid pattern: ") || strings.Contains(err.Error(), "(?i)") {
+			t.Fatalf("unexpected error for %q: %v", cmd, err)
+		}
+	}
+}
@@ -65,6 +65,8 @@ func TestUnescapeStringBestEffort(t *testing.T) {
 		testUnescapeStringBestEffort(t, `\\\"`, `\"`)
 		testUnescapeStringBestEffort(t, `\\\"абв`, `\"абв`)
 		testUnescapeStringBestEffort(t, `йцук\n\"\\Y`, "йцук\n\"\\Y")
+		testUnescapeStringBestEffort(t, `x\ud83d\ude00y`, "x\U0001F600y")
+		testUnescapeStringBestEffort(t, `\ud83dx`, "\ufffdx")
 		testUnescapeStringBestEffort(t, `q\u1234we`, "q\u1234we")
 	})
 
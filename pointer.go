@@ -0,0 +1,140 @@
+package jsonq
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Errors wrapped by PointerError, telling why a JSON Pointer can't be
+// resolved.
+var (
+	// ErrKeyNotFound is returned when an object doesn't contain the key.
+	ErrKeyNotFound = errors.New("key not found")
+
+	// ErrIndexOutOfRange is returned when an array index is greater
+	// than or equal to the array length, including the "-" index.
+	ErrIndexOutOfRange = errors.New("index out of range")
+
+	// ErrInvalidIndex is returned when a reference token applied to an
+	// array isn't a decimal index.
+	ErrInvalidIndex = errors.New("invalid array index")
+
+	// ErrNotContainer is returned when a reference token is applied to
+	// a value which is neither an object nor an array.
+	ErrNotContainer = errors.New("value is not a container")
+)
+
+// PointerError is returned when a JSON Pointer can't be resolved.
+type PointerError struct {
+	// Pointer is the part of the pointer resolved when the error
+	// occurred, including the failing reference token.
+	Pointer string
+
+	// Err is one of ErrKeyNotFound, ErrIndexOutOfRange, ErrInvalidIndex
+	// or ErrNotContainer.
+	Err error
+}
+
+// Error implements the error interface.
+func (e *PointerError) Error() string {
+	return fmt.Sprintf("cannot resolve %q: %s", e.Pointer, e.Err)
+}
+
+// Unwrap returns e.Err.
+func (e *PointerError) Unwrap() error {
+	return e.Err
+}
+
+// Pointer is a JSON Pointer, as described in RFC 6901.
+//
+// It holds the unescaped reference tokens. The empty Pointer refers to the
+// whole document.
+type Pointer []string
+
+// ParsePointer parses the JSON Pointer s, such as `/users/0/name`.
+//
+// "~1" and "~0" are unescaped to "/" and "~" in reference tokens.
+func ParsePointer(s string) (Pointer, error) {
+	if len(s) == 0 {
+		return Pointer{}, nil
+	}
+	if s[0] != '/' {
+		return nil, fmt.Errorf("cannot parse JSON Pointer %q: missing leading '/'", s)
+	}
+	tokens := strings.Split(s[1:], "/")
+	for i, token := range tokens {
+		if strings.IndexByte(token, '~') < 0 {
+			continue
+		}
+		for j := 0; j < len(token); j++ {
+			if token[j] == '~' && (j+1 == len(token) || token[j+1] != '0' && token[j+1] != '1') {
+				return nil, fmt.Errorf("cannot parse JSON Pointer %q: invalid escape sequence in %q", s, token)
+			}
+		}
+		tokens[i] = strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+	}
+	return Pointer(tokens), nil
+}
+
+// String returns the JSON Pointer representation of p.
+func (p Pointer) String() string {
+	var b []byte
+	for _, token := range p {
+		b = append(b, '/')
+		b = append(b, strings.Replace(strings.Replace(token, "~", "~0", -1), "/", "~1", -1)...)
+	}
+	return string(b)
+}
+
+// Get returns the value p refers to in v.
+//
+// A *PointerError is returned if p can't be resolved.
+//
+// The returned value is valid until Parse is called on the Parser returned v.
+func (p Pointer) Get(v *Value) (*Value, error) {
+	for i, token := range p {
+		fail := func(err error) (*Value, error) {
+			return nil, &PointerError{Pointer: p[:i+1].String(), Err: err}
+		}
+		switch v.t {
+		case TypeObject:
+			v = v.o.Get(token)
+			if v == nil {
+				return fail(ErrKeyNotFound)
+			}
+		case TypeArray:
+			if token == "-" {
+				return fail(ErrIndexOutOfRange)
+			}
+			if len(token) == 0 || len(token) > 1 && token[0] == '0' || token[0] == '+' {
+				return fail(ErrInvalidIndex)
+			}
+			n, err := strconv.Atoi(token)
+			if err != nil || n < 0 {
+				return fail(ErrInvalidIndex)
+			}
+			if n >= len(v.a) {
+				return fail(ErrIndexOutOfRange)
+			}
+			v = v.a[n]
+		default:
+			return fail(ErrNotContainer)
+		}
+	}
+	return v, nil
+}
+
+// GetPointer returns the value the JSON Pointer pointer refers to in v.
+//
+// A *PointerError is returned if pointer can't be resolved.
+//
+// The returned value is valid until Parse is called on the Parser returned v.
+func (v *Value) GetPointer(pointer string) (*Value, error) {
+	p, err := ParsePointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+	return p.Get(v)
+}
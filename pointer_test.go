@@ -0,0 +1,97 @@
+package jsonq
+
+import (
+	"testing"
+)
+
+func TestParsePointer(t *testing.T) {
+	f := func(s string, expected ...string) {
+		t.Helper()
+		p, err := ParsePointer(s)
+		if err != nil {
+			t.Fatalf("unexpected error when parsing %q: %s", s, err)
+		}
+		if len(p) != len(expected) {
+			t.Fatalf("unexpected tokens for %q; got %q; want %q", s, p, expected)
+		}
+		for i := range p {
+			if p[i] != expected[i] {
+				t.Fatalf("unexpected tokens for %q; got %q; want %q", s, p, expected)
+			}
+		}
+		if p.String() != s {
+			t.Fatalf("unexpected String() for %q; got %q", s, p.String())
+		}
+	}
+	f(``)
+	f(`/`, ``)
+	f(`/foo/0`, `foo`, `0`)
+	f(`/a~1b/m~0n/~01`, `a/b`, `m~n`, `~1`)
+	f(`//x`, ``, `x`)
+
+	for _, s := range []string{`foo`, `/a~`, `/a~2`, `/~x`} {
+		if _, err := ParsePointer(s); err == nil {
+			t.Fatalf("expecting non-nil error when parsing %q", s)
+		}
+	}
+}
+
+func TestGetPointer(t *testing.T) {
+	var p Parser
+	v, err := p.Parse(`{
+		"foo": ["bar", "baz"],
+		"": 0,
+		"a/b": 1,
+		"c%d": 2,
+		"i\\j": 5,
+		" ": 7,
+		"m~n": 8,
+		"nested": {"list": [{"id": 42}]}
+	}`)
+	if err != nil {
+		t.Fatalf("cannot parse json: %s", err)
+	}
+	f := func(pointer, expected string) {
+		t.Helper()
+		got, err := v.GetPointer(pointer)
+		if err != nil {
+			t.Fatalf("unexpected error for %q: %s", pointer, err)
+		}
+		if got.String() != expected {
+			t.Fatalf("unexpected value for %q; got %s; want %s", pointer, got, expected)
+		}
+	}
+
+	// Examples from RFC 6901, section 5.
+	f(``, v.String())
+	f(`/foo`, `["bar","baz"]`)
+	f(`/foo/0`, `"bar"`)
+	f(`/`, `0`)
+	f(`/a~1b`, `1`)
+	f(`/c%d`, `2`)
+	f(`/i\j`, `5`)
+	f(`/ `, `7`)
+	f(`/m~0n`, `8`)
+	f(`/nested/list/0/id`, `42`)
+
+	fe := func(pointer, expectedPointer string, expectedErr error) {
+		t.Helper()
+		_, err := v.GetPointer(pointer)
+		pe, ok := err.(*PointerError)
+		if !ok {
+			t.Fatalf("unexpected error for %q; got %v; want *PointerError", pointer, err)
+		}
+		if pe.Err != expectedErr || pe.Pointer != expectedPointer {
+			t.Fatalf("unexpected error for %q; got %q, %v; want %q, %v", pointer, pe.Pointer, pe.Err, expectedPointer, expectedErr)
+		}
+	}
+	fe(`/missing`, `/missing`, ErrKeyNotFound)
+	fe(`/nested/missing/0`, `/nested/missing`, ErrKeyNotFound)
+	fe(`/foo/2`, `/foo/2`, ErrIndexOutOfRange)
+	fe(`/foo/-`, `/foo/-`, ErrIndexOutOfRange)
+	fe(`/foo/01`, `/foo/01`, ErrInvalidIndex)
+	fe(`/foo/-1`, `/foo/-1`, ErrInvalidIndex)
+	fe(`/foo/x`, `/foo/x`, ErrInvalidIndex)
+	fe(`/foo/0/x`, `/foo/0/x`, ErrNotContainer)
+	fe(`/a~1b/0`, `/a~1b/0`, ErrNotContainer)
+}
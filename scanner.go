@@ -0,0 +1,214 @@
+package jsonq
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// minScannerRead is the minimum number of bytes the Scanner asks
+// the underlying reader for.
+const minScannerRead = 4096
+
+// Scanner scans a series of JSON values read from a string, a byte slice
+// or an io.Reader.
+//
+// Values may be concatenated or delimited by whitespace, so Scanner may be
+// used for reading newline-delimited JSON ( http://ndjson.org/ ).
+//
+// The input isn't copied up front: only the value being parsed is held
+// in memory, so arbitrary large streams may be scanned.
+//
+// Scanner may be re-used for subsequent parsing.
+//
+// Scanner cannot be used from concurrent goroutines.
+//
+// Use Parser for parsing only a single JSON value.
+type Scanner struct {
+	r io.Reader
+
+	// sr and br are used as r by Init and InitBytes.
+	sr strings.Reader
+	br bytes.Reader
+
+	// b contains the bytes read from r. b[off:] is not parsed yet.
+	b   []byte
+	off int
+
+	// raw contains the JSON text of the last parsed value.
+	raw []byte
+
+	// n is the number of values parsed so far.
+	n int
+
+	eof bool
+	err error
+	v   *Value
+
+	// c is a cache for json values, reset on every Next call.
+	c cache
+}
+
+// NewScanner returns a Scanner reading JSON values from r.
+func NewScanner(r io.Reader) *Scanner {
+	var sc Scanner
+	sc.InitReader(r)
+	return &sc
+}
+
+// Init initializes sc with the given s.
+//
+// s may contain multiple JSON values, which may be delimited by whitespace.
+// Like with InitBytes, s is copied as it is scanned.
+func (sc *Scanner) Init(s string) {
+	sc.sr.Reset(s)
+	sc.InitReader(&sc.sr)
+}
+
+// InitBytes initializes sc with the given b.
+//
+// b may contain multiple JSON values, which may be delimited by whitespace.
+//
+// b isn't modified by sc. Since strings are unescaped in place, b is copied
+// into the buffer of sc as it is scanned, like an io.Reader would be, so
+// only the value being parsed is held twice in memory.
+func (sc *Scanner) InitBytes(b []byte) {
+	sc.br.Reset(b)
+	sc.InitReader(&sc.br)
+}
+
+// InitReader initializes sc with the given r.
+//
+// r may produce multiple JSON values, which may be delimited by whitespace.
+func (sc *Scanner) InitReader(r io.Reader) {
+	sc.r = r
+	sc.b = sc.b[:0]
+	sc.off = 0
+	sc.raw = nil
+	sc.n = 0
+	sc.eof = false
+	sc.err = nil
+	sc.v = nil
+}
+
+// Next parses the next JSON value from the stream.
+//
+// Returns true on success. The parsed value is available via Value call.
+//
+// Returns false either on error or on the end of the stream.
+// Call Error in order to determine the cause of the returned false.
+func (sc *Scanner) Next() bool {
+	if sc.err != nil {
+		return false
+	}
+	sc.v = nil
+	sc.raw = nil
+
+	// need is the number of bytes to buffer before parsing again a value
+	// found truncated, so that large values aren't parsed once per read.
+	need := 0
+	for {
+		s := skipWS(b2s(sc.b[sc.off:]))
+		sc.off = len(sc.b) - len(s)
+		if len(s) == 0 || len(s) < need && !sc.eof {
+			if len(s) == 0 && sc.eof {
+				sc.err = io.EOF
+				return false
+			}
+			if !sc.fill() {
+				return false
+			}
+			continue
+		}
+
+		sc.c.reset()
+		v, tail, err := parseValue(s, &sc.c)
+		if err == nil && (len(tail) > 0 || sc.eof) {
+			// The value is complete: it is followed by other bytes or it
+			// ends the stream. A value ending exactly at the end of the
+			// buffer may be a truncated number, so more data is read first.
+			n := len(s) - len(tail)
+			sc.raw = sc.b[sc.off : sc.off+n]
+			sc.off += n
+			sc.n++
+			sc.v = v
+			return true
+		}
+		if err != nil && (sc.eof || !truncated(tail)) {
+			sc.err = fmt.Errorf("cannot parse JSON value #%d: %s", sc.n+1, err)
+			return false
+		}
+		if err != nil {
+			need = 2 * len(s)
+		}
+		if !sc.fill() {
+			return false
+		}
+	}
+}
+
+// truncated returns true if the parse error leaving tail may be caused by
+// the end of the buffered bytes, so that reading more data may fix it.
+func truncated(tail string) bool {
+	if len(tail) == 0 {
+		return true
+	}
+	for _, literal := range []string{"true", "false", "null"} {
+		if strings.HasPrefix(literal, tail) {
+			return true
+		}
+	}
+	return false
+}
+
+// fill reads more data from r into sc.b, discarding the already parsed bytes.
+//
+// Values previously returned by sc are invalidated.
+func (sc *Scanner) fill() bool {
+	if sc.off > 0 {
+		n := copy(sc.b, sc.b[sc.off:])
+		sc.b = sc.b[:n]
+		sc.off = 0
+	}
+	if cap(sc.b)-len(sc.b) < minScannerRead {
+		b := make([]byte, len(sc.b), 2*cap(sc.b)+minScannerRead)
+		copy(b, sc.b)
+		sc.b = b
+	}
+	n, err := sc.r.Read(sc.b[len(sc.b):cap(sc.b)])
+	sc.b = sc.b[:len(sc.b)+n]
+	if err == io.EOF {
+		sc.eof = true
+	} else if err != nil {
+		sc.err = err
+		return false
+	}
+	return true
+}
+
+// Value returns the last parsed value.
+//
+// The value is valid until the Next call.
+func (sc *Scanner) Value() *Value {
+	return sc.v
+}
+
+// Bytes returns the JSON text of the last parsed value.
+//
+// The returned slice is valid until the Next call. Its content is undefined
+// once strings of the value have been accessed, since they are unescaped
+// in place.
+func (sc *Scanner) Bytes() []byte {
+	return sc.raw
+}
+
+// Error returns the last error.
+//
+// nil is returned if the end of the stream has been reached.
+func (sc *Scanner) Error() error {
+	if sc.err == io.EOF {
+		return nil
+	}
+	return sc.err
+}
@@ -0,0 +1,178 @@
+package jsonq
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestScanner(t *testing.T) {
+	f := func(s string, expected []string) {
+		t.Helper()
+		var fromString, fromBytes Scanner
+		fromString.Init(s)
+		fromBytes.InitBytes([]byte(s))
+		for _, r := range []struct {
+			name string
+			sc   *Scanner
+		}{
+			{"string", &fromString},
+			{"bytes", &fromBytes},
+			{"reader", NewScanner(strings.NewReader(s))},
+			{"one byte reader", NewScanner(iotest.OneByteReader(strings.NewReader(s)))},
+		} {
+			var got []string
+			for r.sc.Next() {
+				got = append(got, r.sc.Value().String())
+			}
+			if err := r.sc.Error(); err != nil {
+				t.Fatalf("%s: unexpected error when scanning %q: %s", r.name, s, err)
+			}
+			if strings.Join(got, "|") != strings.Join(expected, "|") {
+				t.Fatalf("%s: unexpected values scanned from %q; got %q; want %q", r.name, s, got, expected)
+			}
+		}
+	}
+	f("", nil)
+	f("  \n\t ", nil)
+	f("123", []string{"123"})
+	f(`{"a":1}{"b":2}`, []string{`{"a":1}`, `{"b":2}`})
+	f("{\"a\":1}\n{\"b\":[1,2]}\n", []string{`{"a":1}`, `{"b":[1,2]}`})
+	f(`1 23 456 "foo" true null []`, []string{"1", "23", "456", `"foo"`, "true", "null", "[]"})
+
+	// A record larger than the read buffer.
+	large := `{"k":"` + strings.Repeat("x", 3*minScannerRead) + `"}`
+	f(large+"\n"+large, []string{large, large})
+}
+
+func TestScannerReuse(t *testing.T) {
+	var sc Scanner
+	for i := 0; i < 10; i++ {
+		sc.Init(`{"foo": "bar"} [1,2] "baz"`)
+		n := 0
+		for sc.Next() {
+			n++
+		}
+		if err := sc.Error(); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if n != 3 {
+			t.Fatalf("unexpected number of values; got %d; want 3", n)
+		}
+	}
+
+	sc.Init(`[`)
+	if sc.Next() || sc.Error() == nil {
+		t.Fatalf("expecting non-nil error when scanning %q", `[`)
+	}
+	b := []byte(`"foo\nbar"`)
+	sc.InitBytes(b)
+	if !sc.Next() {
+		t.Fatalf("unexpected error: %s", sc.Error())
+	}
+	if sb, err := sc.Value().StringBytes(); err != nil || string(sb) != "foo\nbar" {
+		t.Fatalf("unexpected string; got %q; want %q", sb, "foo\nbar")
+	}
+	if string(b) != `"foo\nbar"` {
+		t.Fatalf("InitBytes input must not be modified; got %q", b)
+	}
+}
+
+func TestScannerError(t *testing.T) {
+	f := func(s string, expectedValues int) {
+		t.Helper()
+		sc := NewScanner(strings.NewReader(s))
+		n := 0
+		for sc.Next() {
+			n++
+		}
+		if sc.Error() == nil {
+			t.Fatalf("expecting non-nil error when scanning %q", s)
+		}
+		if n != expectedValues {
+			t.Fatalf("unexpected number of values scanned from %q; got %d; want %d", s, n, expectedValues)
+		}
+		if sc.Next() {
+			t.Fatalf("Next must return false after an error")
+		}
+	}
+	f("[", 0)
+	f(`{"a":1} {"b"`, 1)
+	f(`1 2 foo`, 2)
+	f(`{"a":1},{"b":2}`, 1)
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += n
+	return n, err
+}
+
+func TestScannerErrorReadAhead(t *testing.T) {
+	tail := strings.Repeat(`{"a":1}`+"\n", 1<<17)
+	f := func(invalid string) {
+		t.Helper()
+		// The invalid value must be reported without reading the rest of
+		// the stream.
+		cr := &countingReader{}
+		for _, r := range []io.Reader{cr, iotest.OneByteReader(cr)} {
+			cr.n = 0
+			cr.r = strings.NewReader(invalid + "\n" + tail)
+			sc := NewScanner(r)
+			for sc.Next() {
+			}
+			err := sc.Error()
+			if err == nil {
+				t.Fatalf("expecting non-nil error when scanning %q", invalid)
+			}
+			if cr.n > 4*minScannerRead {
+				t.Fatalf("too many bytes read before failing on %q: %d", invalid, cr.n)
+			}
+			if len(err.Error()) > 200 {
+				t.Fatalf("too long error message: %q", err)
+			}
+		}
+	}
+	f("foo")
+	f(`{"a":1} [1, 2 tru`)
+	f(`[1, 2, x`)
+	f(`{"a" 1`)
+}
+
+func TestKeepStream(t *testing.T) {
+	input := `{"id":1,"name":"foo","tag":"a"}
+{"id":2,"name":"bar","tag":"b"}
+{"id":3,"name":"baz","tag":"a"}`
+	request := MustParseQuery(`(tag = a){name}`)
+
+	var bb bytes.Buffer
+	if err := KeepStream(strings.NewReader(input), &bb, *request); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := "{\"name\":\"foo\"}\n{\"name\":\"baz\"}\n"
+	if bb.String() != expected {
+		t.Fatalf("unexpected output; got %q; want %q", bb.String(), expected)
+	}
+
+	bb.Reset()
+	if err := CheckStream(strings.NewReader(input), &bb, *request); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected = "{\"id\":1,\"name\":\"foo\",\"tag\":\"a\"}\n{\"id\":3,\"name\":\"baz\",\"tag\":\"a\"}\n"
+	if bb.String() != expected {
+		t.Fatalf("unexpected output; got %q; want %q", bb.String(), expected)
+	}
+
+	bb.Reset()
+	if err := RetrieveStream(strings.NewReader(input+"\n{"), &bb, *request); err == nil {
+		t.Fatalf("expecting non-nil error for truncated stream")
+	}
+}
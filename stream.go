@@ -0,0 +1,77 @@
+package jsonq
+
+import (
+	"bufio"
+	"io"
+)
+
+// CheckStream reads a stream of JSON values from r and writes to w,
+// one per line, the values matching request.
+//
+// Values may be concatenated or newline-delimited. Values not matching
+// the request filters are dropped.
+func CheckStream(r io.Reader, w io.Writer, request Query) error {
+	var raw []byte
+	return stream(r, w, func(sc *Scanner, w *bufio.Writer) (bool, error) {
+		// Check unescapes strings in place, so the raw text must be
+		// saved before.
+		raw = append(raw[:0], sc.Bytes()...)
+		if err := sc.Value().Check(request); err != nil {
+			return false, nil
+		}
+		_, err := w.Write(raw)
+		return true, err
+	})
+}
+
+// KeepStream reads a stream of JSON values from r and writes to w,
+// one per line, the result of Keep applied on every value.
+//
+// Values may be concatenated or newline-delimited. Values not matching
+// the request filters are dropped.
+func KeepStream(r io.Reader, w io.Writer, request Query) error {
+	return stream(r, w, func(sc *Scanner, w *bufio.Writer) (bool, error) {
+		if err := sc.Value().Check(request); err != nil {
+			return false, nil
+		}
+		return true, sc.Value().keepTo(w, request)
+	})
+}
+
+// RetrieveStream reads a stream of JSON values from r and writes to w,
+// one per line, the result of Retrieve applied on every value.
+//
+// Values may be concatenated or newline-delimited. Values not matching
+// the request filters are dropped.
+func RetrieveStream(r io.Reader, w io.Writer, request Query) error {
+	return stream(r, w, func(sc *Scanner, w *bufio.Writer) (bool, error) {
+		if err := sc.Value().Check(request); err != nil {
+			return false, nil
+		}
+		return true, sc.Value().retrieveTo(w, request)
+	})
+}
+
+// stream calls f for every value read from r. f writes its result to w
+// and returns true if it wrote something.
+func stream(r io.Reader, w io.Writer, f func(sc *Scanner, w *bufio.Writer) (bool, error)) error {
+	bw := bufio.NewWriter(w)
+	sc := NewScanner(r)
+	for sc.Next() {
+		written, err := f(sc, bw)
+		if err != nil {
+			return err
+		}
+		if !written {
+			continue
+		}
+		if err := bw.WriteByte('\n'); err != nil {
+			return err
+		}
+	}
+	if err := sc.Error(); err != nil {
+		bw.Flush()
+		return err
+	}
+	return bw.Flush()
+}